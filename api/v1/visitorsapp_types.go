@@ -0,0 +1,632 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImageSpec selects the container image of a tier. Unset fields fall back to
+// the operator's built-in defaults for that tier.
+type ImageSpec struct {
+	// Repository is the image name without tag or digest,
+	// e.g. "kerryduan/visitors-service".
+	//+optional
+	Repository string `json:"repository,omitempty"`
+
+	//+optional
+	Tag string `json:"tag,omitempty"`
+
+	// Digest pins the image by content and takes precedence over Tag.
+	//+kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	//+optional
+	Digest string `json:"digest,omitempty"`
+
+	//+kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	//+optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// AutoScalingSpec hands the replicas of a tier over to a HorizontalPodAutoscaler.
+type AutoScalingSpec struct {
+	// Enabled leaves the replicas to a HorizontalPodAutoscaler. Unless
+	// horizontalPodAutoscaler is set, the autoscaler has to be created by hand.
+	//+optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// HorizontalPodAutoscaler makes the operator manage a HorizontalPodAutoscaler
+	// for the tier. Setting it implies enabled.
+	//+optional
+	HorizontalPodAutoscaler *AutoScalerSpec `json:"horizontalPodAutoscaler,omitempty"`
+}
+
+// AutoScalerSpec configures the HorizontalPodAutoscaler the operator manages for a tier.
+type AutoScalerSpec struct {
+	// MinReplicas defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the average CPU utilization, relative
+	// to the CPU requests, the autoscaler aims for.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// TargetMemoryUtilizationPercentage is the average memory utilization,
+	// relative to the memory requests, the autoscaler aims for.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+
+	// Metrics are used in addition to the CPU and memory targets, e.g. custom
+	// metrics served by the prometheus adapter.
+	//+optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+
+	// Behavior configures the scaling policies in both directions.
+	//+optional
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// ServiceSpec configures how the Service of a tier is exposed.
+type ServiceSpec struct {
+	// Type of the Service. Defaults to NodePort.
+	//+kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	//+optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// NodePort of a NodePort or LoadBalancer Service. It is allocated
+	// automatically when omitted.
+	//+kubebuilder:validation:Minimum=30000
+	//+kubebuilder:validation:Maximum=32767
+	//+optional
+	NodePort int32 `json:"nodePort,omitempty"`
+
+	// Annotations added to the Service, e.g. to configure a cloud load balancer.
+	//+optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// LoadBalancerSourceRanges restricts the clients of a LoadBalancer Service.
+	//+optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+
+	// ExternalTrafficPolicy of a NodePort or LoadBalancer Service.
+	//+kubebuilder:validation:Enum=Cluster;Local
+	//+optional
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+}
+
+// TierSpec defines the desired state of the pods and the Service of a tier.
+type TierSpec struct {
+	// Size is the number of pods when auto-scaling is off. Defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Size int32 `json:"size,omitempty"`
+
+	//+optional
+	Image ImageSpec `json:"image,omitempty"`
+
+	// Resources of the tier's container. Defaults to a CPU request of 200m
+	// for the backend and of 500m for the frontend, which gives the pods the
+	// Burstable QoS class.
+	//+optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// StartupProbe replaces the default startup probe of the tier's container,
+	// which waits up to 150s for the container port to answer.
+	//+optional
+	StartupProbe *corev1.Probe `json:"startupProbe,omitempty"`
+
+	// LivenessProbe replaces the default liveness probe of the tier's container,
+	// which checks the container port every 10s.
+	//+optional
+	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
+
+	// ReadinessProbe replaces the default readiness probe of the tier's
+	// container. The backend is ready once it serves "/visitors/", which needs
+	// the database connection, and the frontend once it serves "/".
+	//+optional
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	//+optional
+	Service ServiceSpec `json:"service,omitempty"`
+
+	//+optional
+	AutoScaling AutoScalingSpec `json:"autoscaling,omitempty"`
+
+	// DisableAutoRollback leaves a release of the tier whose pods do not become
+	// available in place. By default, the tier goes back to the last pod
+	// template that rolled out when its pods exceed the progress deadline or
+	// crash loop.
+	//+optional
+	DisableAutoRollback bool `json:"disableAutoRollback,omitempty"`
+}
+
+// BackendSpec defines the desired state of the backend tier.
+type BackendSpec struct {
+	TierSpec `json:",inline"`
+
+	// RolloutStrategy decides how changes to the pods of the backend are
+	// rolled out. Defaults to a rolling update of its Deployment.
+	//+optional
+	RolloutStrategy RolloutStrategy `json:"rolloutStrategy,omitempty"`
+}
+
+// RolloutStrategyType is the way the backend pods are replaced
+// +kubebuilder:validation:Enum=RollingUpdate;Canary;BlueGreen
+type RolloutStrategyType string
+
+const (
+	// RolloutStrategyRollingUpdate updates the pods of the backend Deployment
+	// in place, with its rolling update strategy.
+	RolloutStrategyRollingUpdate RolloutStrategyType = "RollingUpdate"
+	// RolloutStrategyCanary runs the new pods in a candidate Deployment next to
+	// the current ones, and sends them a growing share of the traffic.
+	RolloutStrategyCanary RolloutStrategyType = "Canary"
+	// RolloutStrategyBlueGreen runs a full set of new pods in a candidate
+	// Deployment without traffic, then switches all of the traffic to them.
+	RolloutStrategyBlueGreen RolloutStrategyType = "BlueGreen"
+)
+
+// RolloutStrategy configures the rollout of the backend pods
+type RolloutStrategy struct {
+	// Type of the rollout. Defaults to RollingUpdate.
+	//+optional
+	Type RolloutStrategyType `json:"type,omitempty"`
+
+	// Canary configures the Canary rollouts, which cannot be combined with
+	// auto-scaling of the backend.
+	//+optional
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+
+	// BlueGreen configures the BlueGreen rollouts.
+	//+optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// CanaryStrategy shifts the traffic to the new pods in steps. The traffic
+// follows the share of the backend pods running the new version, as the
+// backend Service balances its connections over all of them.
+type CanaryStrategy struct {
+	// Steps run in order, before all of the pods are updated.
+	//+kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+}
+
+// CanaryStep is a share of the pods running the new version, held for a while
+type CanaryStep struct {
+	// Weight is the percentage of the backend pods running the new version,
+	// rounded up to a whole number of pods.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	// Pause is how long the step lasts once its pods are available, e.g. "5m".
+	//+optional
+	Pause metav1.Duration `json:"pause,omitempty"`
+}
+
+// BlueGreenStrategy switches the traffic at once
+type BlueGreenStrategy struct {
+	// PreviewDuration is how long the new pods run without traffic once they
+	// are available, before the traffic is switched to them.
+	//+optional
+	PreviewDuration metav1.Duration `json:"previewDuration,omitempty"`
+}
+
+// FrontendSpec defines the desired state of the frontend tier.
+type FrontendSpec struct {
+	TierSpec `json:",inline"`
+
+	// Title shown by the frontend. Defaults to "Visitors Site".
+	//+optional
+	Title string `json:"title,omitempty"`
+}
+
+// IngressSpec configures the Ingress the operator manages in front of both tiers.
+type IngressSpec struct {
+	// Host the Ingress serves. All hosts are matched when omitted.
+	//+optional
+	Host string `json:"host,omitempty"`
+
+	// Path the frontend is served at. The backend is served at "<path>/api".
+	// Defaults to "/".
+	//+kubebuilder:validation:Pattern=`^/`
+	//+optional
+	Path string `json:"path,omitempty"`
+
+	//+optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// TLSSecretName enables TLS for the host with the certificate in this Secret.
+	//+optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// Annotations added to the Ingress, e.g. to configure the ingress controller.
+	//+optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// DatabaseSpec references the presslabs MysqlCluster the backend connects to.
+// Unset fields fall back to the names used by the samples in config/samples/mysql.
+type DatabaseSpec struct {
+	// Managed makes the operator create and own the MysqlCluster and its
+	// credentials Secret, instead of waiting for them to be applied by hand.
+	//+optional
+	Managed bool `json:"managed,omitempty"`
+
+	// Size is the number of MySQL replicas of the managed MysqlCluster.
+	// Defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Size *int32 `json:"size,omitempty"`
+
+	// MysqlVersion of the managed MysqlCluster. Defaults to "5.7.31".
+	//+optional
+	MysqlVersion string `json:"mysqlVersion,omitempty"`
+
+	// Resources of the MySQL pods of the managed MysqlCluster.
+	//+optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ClusterName is the name of the MysqlCluster. Defaults to "my-cluster",
+	// or to "<name>-db" when the cluster is managed.
+	//+optional
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Namespace of the MysqlCluster. Defaults to the namespace of the
+	// VisitorsApp, which is also the only namespace a managed cluster can live in.
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SecretName is the Secret holding the application credentials. It is
+	// always read from the namespace of the VisitorsApp, since pods can only
+	// reference Secrets in their own namespace. Defaults to "my-secret", or to
+	// "<name>-db-credentials" when the cluster is managed.
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// UserKey is the key of the user name in the Secret. Defaults to "USER".
+	//+optional
+	UserKey string `json:"userKey,omitempty"`
+
+	// PasswordKey is the key of the password in the Secret. Defaults to "PASSWORD".
+	//+optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+
+	// RootPasswordKey is the key of the MySQL root password in the Secret,
+	// which the schema, backup and restore Jobs connect with. Defaults to
+	// "ROOT_PASSWORD".
+	//+optional
+	RootPasswordKey string `json:"rootPasswordKey,omitempty"`
+
+	// ServiceRWName is the read-write (master) Service of the cluster.
+	// Defaults to "<clusterName>-mysql-master".
+	//+optional
+	ServiceRWName string `json:"serviceRWName,omitempty"`
+
+	// ServiceROName is the read-only Service of the cluster.
+	// Defaults to "<clusterName>-mysql".
+	//+optional
+	ServiceROName string `json:"serviceROName,omitempty"`
+
+	// DatabaseName is the schema the backend uses. Defaults to "visitors_db".
+	//+optional
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// DropOnDelete drops the database and its user from a managed cluster that
+	// is kept by the Retain or Orphan deletion policy.
+	//+optional
+	DropOnDelete bool `json:"dropOnDelete,omitempty"`
+
+	// Schema makes the operator run a Job creating the database and the
+	// application user and applying the schema migrations. The backend is
+	// only rolled out once the Job succeeded.
+	//+optional
+	Schema *SchemaSpec `json:"schema,omitempty"`
+}
+
+// SchemaSpec configures the Job preparing the database for the backend
+type SchemaSpec struct {
+	// Image of the Job, which needs a shell and the mysql client.
+	// Defaults to "mysql:<mysqlVersion>".
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// MigrationsConfigMap is a ConfigMap in the namespace of the VisitorsApp
+	// holding one SQL script per key, such as "0001_visits.sql". Each script
+	// is applied once, in the order of the keys. The key of the last one,
+	// without its ".sql" extension, is the schema version.
+	//+optional
+	MigrationsConfigMap string `json:"migrationsConfigMap,omitempty"`
+}
+
+// DeletionPolicy decides which of the owned objects outlive a deleted VisitorsApp
+// +kubebuilder:validation:Enum=Delete;Retain;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes all the objects owned by the VisitorsApp with it.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain keeps the Services, with their node ports, and the
+	// managed MysqlCluster with its credentials Secret.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyOrphan keeps all the objects owned by the VisitorsApp.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// VisitorsAppSpec defines the desired state of VisitorsApp
+// +k8s:openapi-gen=true
+type VisitorsAppSpec struct {
+	//+optional
+	Backend BackendSpec `json:"backend,omitempty"`
+
+	//+optional
+	Frontend FrontendSpec `json:"frontend,omitempty"`
+
+	//+optional
+	Database DatabaseSpec `json:"database,omitempty"`
+
+	// Ingress makes the operator manage an Ingress routing to the frontend and
+	// the backend. The Ingress is deleted when the section is removed.
+	//+optional
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+
+	// DeletionPolicy decides which of the owned objects are kept when the
+	// VisitorsApp is deleted. Defaults to Delete.
+	//+optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Backup makes the operator back up the VisitorsApp on a schedule.
+	//+optional
+	Backup *ScheduledBackupSpec `json:"backup,omitempty"`
+}
+
+// ScheduledBackupSpec configures the VisitorsAppBackups the operator creates
+// on a schedule
+type ScheduledBackupSpec struct {
+	// Schedule in the cron format, e.g. "0 3 * * *" for every day at 3am.
+	Schedule string `json:"schedule"`
+
+	// Target is where the dumps are stored.
+	Target BackupTarget `json:"target"`
+
+	// Image of the Jobs dumping the database, which needs mysqldump.
+	// Defaults to "mysql:<mysqlVersion>".
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Retention decides which scheduled backups are deleted, with their dumps.
+	// Defaults to keeping the last 7.
+	//+optional
+	Retention BackupRetention `json:"retention,omitempty"`
+}
+
+// BackupRetention bounds the number and the age of the scheduled backups kept
+type BackupRetention struct {
+	// KeepLast is the number of completed backups kept.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	KeepLast *int32 `json:"keepLast,omitempty"`
+
+	// MaxAge is the age after which backups are deleted, e.g. "720h".
+	//+optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// Condition types reported in the status of a VisitorsApp
+const (
+	// ConditionDatabaseReady tells whether the MySQL cluster accepts connections.
+	ConditionDatabaseReady = "DatabaseReady"
+	// ConditionBackendAvailable mirrors the Available condition of the backend Deployment.
+	ConditionBackendAvailable = "BackendAvailable"
+	// ConditionFrontendAvailable mirrors the Available condition of the frontend Deployment.
+	ConditionFrontendAvailable = "FrontendAvailable"
+	// ConditionSchemaReady tells whether the schema Job succeeded. It is only
+	// reported when the database has a schema section.
+	ConditionSchemaReady = "SchemaReady"
+	// ConditionReady is True when all of the above are True.
+	ConditionReady = "Ready"
+	// ConditionDegraded is True while a tier runs a previous release, after
+	// its current one failed to roll out and was rolled back.
+	ConditionDegraded = "Degraded"
+)
+
+// VisitorsAppPhase is the stage the rollout of a VisitorsApp is at. The tiers
+// roll out one after the other: the backend waits for the database, and the
+// frontend for the backend.
+// +kubebuilder:validation:Enum=WaitingForDatabase;RollingOutBackend;RollingOutFrontend;Running
+type VisitorsAppPhase string
+
+const (
+	// VisitorsAppPhaseWaitingForDatabase waits for the database, and its schema, to be ready.
+	VisitorsAppPhaseWaitingForDatabase VisitorsAppPhase = "WaitingForDatabase"
+	// VisitorsAppPhaseRollingOutBackend waits for the backend Deployment to roll
+	// out, the frontend is not updated meanwhile.
+	VisitorsAppPhaseRollingOutBackend VisitorsAppPhase = "RollingOutBackend"
+	// VisitorsAppPhaseRollingOutFrontend waits for the frontend Deployment to roll out.
+	VisitorsAppPhaseRollingOutFrontend VisitorsAppPhase = "RollingOutFrontend"
+	// VisitorsAppPhaseRunning has every tier rolled out.
+	VisitorsAppPhaseRunning VisitorsAppPhase = "Running"
+)
+
+// TierStatus defines the observed state of a tier
+type TierStatus struct {
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// Replicas is the desired number of pods.
+	//+optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	//+optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// UpdatedReplicas is the number of pods running the current spec.
+	//+optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+
+	// URL is the address the tier can be reached at.
+	//+optional
+	URL string `json:"url,omitempty"`
+
+	// RolledBack reports the release of the tier that failed to roll out, while
+	// the tier runs the previous one instead.
+	//+optional
+	RolledBack *RollbackStatus `json:"rolledBack,omitempty"`
+}
+
+// RollbackStatus describes a release of a tier that was rolled back
+type RollbackStatus struct {
+	// TemplateHash identifies the pod template that failed to roll out. The
+	// tier is kept on the previous one until its pod template changes again.
+	TemplateHash string `json:"templateHash"`
+
+	// Message tells why the rollout failed.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// Time the release was rolled back at.
+	Time metav1.Time `json:"time"`
+}
+
+// RolloutPhase is the stage a Canary or BlueGreen rollout is at
+// +kubebuilder:validation:Enum=Progressing;Paused;Promoting;Completed;Aborted
+type RolloutPhase string
+
+const (
+	// RolloutPhaseProgressing waits for the candidate pods of the current step to be available.
+	RolloutPhaseProgressing RolloutPhase = "Progressing"
+	// RolloutPhasePaused holds the current step, or the preview, for its duration.
+	RolloutPhasePaused RolloutPhase = "Paused"
+	// RolloutPhasePromoting updates the backend Deployment to the new version.
+	RolloutPhasePromoting RolloutPhase = "Promoting"
+	// RolloutPhaseCompleted is final, the backend Deployment runs the new version.
+	RolloutPhaseCompleted RolloutPhase = "Completed"
+	// RolloutPhaseAborted is final, the candidate pods failed or the rollout
+	// was superseded, and they were removed. After a failure the backend keeps
+	// running the previous version until its pod template changes again.
+	RolloutPhaseAborted RolloutPhase = "Aborted"
+)
+
+// RolloutStatus defines the observed state of a Canary or BlueGreen rollout
+type RolloutStatus struct {
+	Strategy RolloutStrategyType `json:"strategy"`
+
+	Phase RolloutPhase `json:"phase"`
+
+	// Message explains the phase.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// TemplateHash identifies the pod template being rolled out.
+	TemplateHash string `json:"templateHash"`
+
+	// Step is the index of the current Canary step.
+	//+optional
+	Step int32 `json:"step,omitempty"`
+
+	// Weight is the percentage of the backend pods running the new version.
+	//+optional
+	Weight int32 `json:"weight,omitempty"`
+
+	// StepStartTime is when the pods of the current step, or of the preview,
+	// were all available.
+	//+optional
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+}
+
+// VisitorsAppStatus defines the observed state of VisitorsApp
+// +k8s:openapi-gen=true
+type VisitorsAppStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	//+optional
+	Phase VisitorsAppPhase `json:"phase,omitempty"`
+
+	//+listType=map
+	//+listMapKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	//+optional
+	Backend TierStatus `json:"backend,omitempty"`
+
+	//+optional
+	Frontend TierStatus `json:"frontend,omitempty"`
+
+	// SchemaVersion is the version of the last migration applied to the database.
+	//+optional
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+
+	// BackendRollout reports the progress of the last Canary or BlueGreen
+	// rollout of the backend.
+	//+optional
+	BackendRollout *RolloutStatus `json:"backendRollout,omitempty"`
+
+	// LastScheduledBackupTime is the time of the last scheduled backup, or
+	// the time the schedule was set up when none ran yet.
+	//+optional
+	LastScheduledBackupTime *metav1.Time `json:"lastScheduledBackupTime,omitempty"`
+}
+
+//+k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Backend",type=integer,JSONPath=`.status.backend.readyReplicas`,description="Ready backend pods"
+//+kubebuilder:printcolumn:name="Backend Desired",type=integer,JSONPath=`.status.backend.replicas`,priority=1
+//+kubebuilder:printcolumn:name="Frontend",type=integer,JSONPath=`.status.frontend.readyReplicas`,description="Ready frontend pods"
+//+kubebuilder:printcolumn:name="Frontend Desired",type=integer,JSONPath=`.status.frontend.replicas`,priority=1
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.frontend.url`
+//+kubebuilder:printcolumn:name="Schema",type=string,JSONPath=`.status.schemaVersion`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VisitorsApp is the Schema for the visitorsapps API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type VisitorsApp struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VisitorsAppSpec   `json:"spec,omitempty"`
+	Status VisitorsAppStatus `json:"status,omitempty"`
+}
+
+//+k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+//+kubebuilder:object:root=true
+
+// VisitorsAppList contains a list of VisitorsApp
+type VisitorsAppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VisitorsApp `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VisitorsApp{}, &VisitorsAppList{})
+}
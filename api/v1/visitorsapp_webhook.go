@@ -0,0 +1,394 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Defaults filled in by the defaulting webhook. The controller falls back to
+// the same values for objects that were admitted without the webhook.
+const (
+	DefaultSize                    = 1
+	DefaultFrontendTitle           = "Visitors Site"
+	DefaultBackendImageRepository  = "kerryduan/visitors-service"
+	DefaultBackendImageTag         = "1.0.0"
+	DefaultFrontendImageRepository = "jdob/visitors-webui"
+	DefaultFrontendImageTag        = "1.0.0"
+	DefaultBackendCPURequest       = "200m"
+	DefaultFrontendCPURequest      = "500m"
+	DefaultBackupKeepLast          = 7
+)
+
+// log is for logging in this package.
+var visitorsapplog = logf.Log.WithName("visitorsapp-resource")
+
+// visitorsappClient lists the other VisitorsApps when looking for node port collisions.
+var visitorsappClient client.Reader
+
+func (r *VisitorsApp) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	visitorsappClient = mgr.GetClient()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-example-com-my-domain-v1-visitorsapp,mutating=true,failurePolicy=fail,sideEffects=None,groups=example.com.my.domain,resources=visitorsapps,verbs=create;update,versions=v1,name=mvisitorsapp.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &VisitorsApp{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *VisitorsApp) Default() {
+	visitorsapplog.Info("default", "name", r.Name)
+
+	defaultTier(&r.Spec.Backend.TierSpec, DefaultBackendImageRepository, DefaultBackendImageTag, DefaultBackendCPURequest)
+	if r.Spec.Backend.Image.PullPolicy == "" {
+		r.Spec.Backend.Image.PullPolicy = corev1.PullAlways
+	}
+	if r.Spec.Backend.RolloutStrategy.Type == "" {
+		r.Spec.Backend.RolloutStrategy.Type = RolloutStrategyRollingUpdate
+	}
+
+	defaultTier(&r.Spec.Frontend.TierSpec, DefaultFrontendImageRepository, DefaultFrontendImageTag, DefaultFrontendCPURequest)
+	if r.Spec.Frontend.Title == "" {
+		r.Spec.Frontend.Title = DefaultFrontendTitle
+	}
+
+	if r.Spec.DeletionPolicy == "" {
+		r.Spec.DeletionPolicy = DeletionPolicyDelete
+	}
+
+	if backup := r.Spec.Backup; backup != nil && backup.Retention.KeepLast == nil && backup.Retention.MaxAge == nil {
+		keepLast := int32(DefaultBackupKeepLast)
+		backup.Retention.KeepLast = &keepLast
+	}
+}
+
+func defaultTier(tier *TierSpec, repository string, tag string, cpuRequest string) {
+	if tier.Size == 0 {
+		tier.Size = DefaultSize
+	}
+	defaultImage(&tier.Image, repository, tag)
+	if len(tier.Resources.Requests) == 0 && len(tier.Resources.Limits) == 0 {
+		tier.Resources.Requests = corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse(cpuRequest),
+		}
+	}
+	if tier.Service.Type == "" {
+		tier.Service.Type = corev1.ServiceTypeNodePort
+	}
+	if tier.AutoScaling.HorizontalPodAutoscaler != nil {
+		tier.AutoScaling.Enabled = true
+	}
+}
+
+func defaultImage(image *ImageSpec, repository string, tag string) {
+	if image.Repository == "" {
+		image.Repository = repository
+	}
+	if image.Tag == "" && image.Digest == "" {
+		image.Tag = tag
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-example-com-my-domain-v1-visitorsapp,mutating=false,failurePolicy=fail,sideEffects=None,groups=example.com.my.domain,resources=visitorsapps,verbs=create;update,versions=v1,name=vvisitorsapp.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &VisitorsApp{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *VisitorsApp) ValidateCreate() error {
+	visitorsapplog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateAutoScalingEnabled(nil)...)
+	allErrs = append(allErrs, r.validateNodePortCollisions()...)
+	return r.invalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *VisitorsApp) ValidateUpdate(old runtime.Object) error {
+	visitorsapplog.Info("validate update", "name", r.Name)
+	oldApp := old.(*VisitorsApp)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateAutoScalingEnabled(oldApp)...)
+	allErrs = append(allErrs, r.validateImmutableFields(oldApp)...)
+	allErrs = append(allErrs, r.validateNodePortCollisions()...)
+	return r.invalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *VisitorsApp) ValidateDelete() error {
+	return nil
+}
+
+func (r *VisitorsApp) invalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("VisitorsApp").GroupKind(), r.Name, allErrs)
+}
+
+// Checks the combinations of fields the CRD schema cannot express
+func (r *VisitorsApp) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	allErrs = append(allErrs, validateTier(r.Spec.Backend.TierSpec, specPath.Child("backend"))...)
+	allErrs = append(allErrs, validateRolloutStrategy(r.Spec.Backend.RolloutStrategy, specPath.Child("backend", "rolloutStrategy"))...)
+	// The autoscaler of the backend would count the canary pods, which the
+	// selector of its Deployment matches, and keep the stable pods at its own
+	// number of replicas
+	autoScaling := r.Spec.Backend.AutoScaling
+	if r.Spec.Backend.RolloutStrategy.Type == RolloutStrategyCanary && (autoScaling.Enabled || autoScaling.HorizontalPodAutoscaler != nil) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("backend", "rolloutStrategy", "type"),
+			"a Canary rollout cannot be combined with auto-scaling of the backend"))
+	}
+	allErrs = append(allErrs, validateTier(r.Spec.Frontend.TierSpec, specPath.Child("frontend"))...)
+
+	backendNodePort := nodePort(r.Spec.Backend.Service)
+	if backendNodePort != 0 && backendNodePort == nodePort(r.Spec.Frontend.Service) {
+		allErrs = append(allErrs, field.Duplicate(specPath.Child("frontend", "service", "nodePort"), backendNodePort))
+	}
+
+	if r.Spec.Database.Managed && r.Spec.Database.Namespace != "" && r.Spec.Database.Namespace != r.Namespace {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("database", "namespace"), r.Spec.Database.Namespace,
+			"a managed database must be in the namespace of the VisitorsApp"))
+	}
+	if r.Spec.Database.DropOnDelete && !r.Spec.Database.Managed {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("database", "dropOnDelete"),
+			"only a managed database can be dropped"))
+	}
+
+	if backup := r.Spec.Backup; backup != nil {
+		allErrs = append(allErrs, validateScheduledBackup(*backup, specPath.Child("backup"))...)
+	}
+
+	return allErrs
+}
+
+func validateRolloutStrategy(strategy RolloutStrategy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if strategy.Canary != nil && strategy.Type != RolloutStrategyCanary {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("canary"), "only allowed with the Canary type"))
+	}
+	if strategy.BlueGreen != nil && strategy.Type != RolloutStrategyBlueGreen {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("blueGreen"), "only allowed with the BlueGreen type"))
+	}
+
+	if strategy.Type == RolloutStrategyCanary {
+		if strategy.Canary == nil || len(strategy.Canary.Steps) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath.Child("canary", "steps"), "a Canary rollout needs steps"))
+			return allErrs
+		}
+		previous := int32(0)
+		for i, step := range strategy.Canary.Steps {
+			stepPath := fldPath.Child("canary", "steps").Index(i)
+			if step.Weight < previous {
+				allErrs = append(allErrs, field.Invalid(stepPath.Child("weight"), step.Weight,
+					"must not be lower than the weight of the previous step"))
+			}
+			if step.Pause.Duration < 0 {
+				allErrs = append(allErrs, field.Invalid(stepPath.Child("pause"), step.Pause.Duration.String(), "must not be negative"))
+			}
+			previous = step.Weight
+		}
+	}
+
+	if strategy.BlueGreen != nil && strategy.BlueGreen.PreviewDuration.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("blueGreen", "previewDuration"),
+			strategy.BlueGreen.PreviewDuration.Duration.String(), "must not be negative"))
+	}
+	return allErrs
+}
+
+func validateScheduledBackup(backup ScheduledBackupSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if _, err := cron.ParseStandard(backup.Schedule); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("schedule"), backup.Schedule, err.Error()))
+	}
+	if (backup.Target.PersistentVolumeClaim == nil) == (backup.Target.S3 == nil) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("target"), backup.Target,
+			"exactly one of persistentVolumeClaim and s3 has to be set"))
+	}
+	if maxAge := backup.Retention.MaxAge; maxAge != nil && maxAge.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("retention", "maxAge"), maxAge.Duration.String(),
+			"must be positive"))
+	}
+	return allErrs
+}
+
+func validateTier(tier TierSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := validateAutoScaler(tier.AutoScaling.HorizontalPodAutoscaler, fldPath.Child("autoscaling", "horizontalPodAutoscaler"))
+	allErrs = append(allErrs, validateResources(tier.Resources, fldPath.Child("resources"))...)
+	return append(allErrs, validateService(tier.Service, fldPath.Child("service"))...)
+}
+
+// The Deployment accepts requests above the limits, but its pods are then
+// rejected, so the mistake is reported here instead
+func validateResources(spec corev1.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, request := range spec.Requests {
+		limit, ok := spec.Limits[name]
+		if ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be less than or equal to the %s limit", name)))
+		}
+	}
+	return allErrs
+}
+
+func validateAutoScaler(spec *AutoScalerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if spec == nil {
+		return allErrs
+	}
+
+	if spec.MaxReplicas < 1 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("maxReplicas"), "auto-scaling needs an upper bound"))
+	}
+	if spec.MinReplicas != nil && *spec.MinReplicas > spec.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minReplicas"), *spec.MinReplicas,
+			"must not be greater than maxReplicas"))
+	}
+	return allErrs
+}
+
+func validateService(spec ServiceSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if spec.Type == corev1.ServiceTypeClusterIP {
+		if spec.NodePort != 0 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("nodePort"), "not allowed for a ClusterIP Service"))
+		}
+		if spec.ExternalTrafficPolicy != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("externalTrafficPolicy"), "not allowed for a ClusterIP Service"))
+		}
+	}
+	if spec.Type != corev1.ServiceTypeLoadBalancer && len(spec.LoadBalancerSourceRanges) > 0 {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("loadBalancerSourceRanges"), "only allowed for a LoadBalancer Service"))
+	}
+	return allErrs
+}
+
+// Auto-scaling without an operator-managed autoscaler has no bounds the
+// operator knows about, so it can no longer be turned on that way. Objects
+// that already rely on it keep being accepted.
+func (r *VisitorsApp) validateAutoScalingEnabled(old *VisitorsApp) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	var oldBackend, oldFrontend *AutoScalingSpec
+	if old != nil {
+		oldBackend = &old.Spec.Backend.AutoScaling
+		oldFrontend = &old.Spec.Frontend.AutoScaling
+	}
+	allErrs = append(allErrs, validateAutoScalingBounds(r.Spec.Backend.AutoScaling, oldBackend,
+		specPath.Child("backend", "autoscaling"))...)
+	allErrs = append(allErrs, validateAutoScalingBounds(r.Spec.Frontend.AutoScaling, oldFrontend,
+		specPath.Child("frontend", "autoscaling"))...)
+	return allErrs
+}
+
+func validateAutoScalingBounds(spec AutoScalingSpec, old *AutoScalingSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if spec.Enabled && spec.HorizontalPodAutoscaler == nil && (old == nil || !old.Enabled) {
+		allErrs = append(allErrs, field.Required(fldPath.Child("horizontalPodAutoscaler"),
+			"auto-scaling needs minReplicas and maxReplicas bounds"))
+	}
+	return allErrs
+}
+
+// The operator owns the managed MysqlCluster and its credentials under these
+// names, changing them would orphan the database
+func (r *VisitorsApp) validateImmutableFields(old *VisitorsApp) field.ErrorList {
+	var allErrs field.ErrorList
+	dbPath := field.NewPath("spec", "database")
+
+	if r.Spec.Database.Managed != old.Spec.Database.Managed {
+		allErrs = append(allErrs, field.Forbidden(dbPath.Child("managed"), "field is immutable"))
+	}
+	if old.Spec.Database.Managed {
+		if r.Spec.Database.ClusterName != old.Spec.Database.ClusterName {
+			allErrs = append(allErrs, field.Forbidden(dbPath.Child("clusterName"), "field is immutable for a managed database"))
+		}
+		if r.Spec.Database.SecretName != old.Spec.Database.SecretName {
+			allErrs = append(allErrs, field.Forbidden(dbPath.Child("secretName"), "field is immutable for a managed database"))
+		}
+	}
+	return allErrs
+}
+
+// Node ports are allocated cluster-wide, so they are compared against the
+// VisitorsApps of every namespace
+func (r *VisitorsApp) validateNodePortCollisions() field.ErrorList {
+	var allErrs field.ErrorList
+	if visitorsappClient == nil {
+		return allErrs
+	}
+
+	apps := &VisitorsAppList{}
+	if err := visitorsappClient.List(context.Background(), apps); err != nil {
+		return append(allErrs, field.InternalError(field.NewPath("spec"), err))
+	}
+
+	specPath := field.NewPath("spec")
+	ports := map[int32]*field.Path{}
+	if port := nodePort(r.Spec.Backend.Service); port != 0 {
+		ports[port] = specPath.Child("backend", "service", "nodePort")
+	}
+	if port := nodePort(r.Spec.Frontend.Service); port != 0 {
+		ports[port] = specPath.Child("frontend", "service", "nodePort")
+	}
+
+	for _, other := range apps.Items {
+		if other.Namespace == r.Namespace && other.Name == r.Name {
+			continue
+		}
+		for _, port := range []int32{
+			nodePort(other.Spec.Backend.Service),
+			nodePort(other.Spec.Frontend.Service),
+		} {
+			if fldPath, ok := ports[port]; ok {
+				allErrs = append(allErrs, field.Invalid(fldPath, port,
+					fmt.Sprintf("node port is already used by VisitorsApp %s/%s", other.Namespace, other.Name)))
+			}
+		}
+	}
+	return allErrs
+}
+
+// Returns the node port a tier requests, ClusterIP Services have none
+func nodePort(spec ServiceSpec) int32 {
+	if spec.Type == corev1.ServiceTypeClusterIP {
+		return 0
+	}
+	return spec.NodePort
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("VisitorsApp webhooks", func() {
+	newApp := func(name string, backendNodePort int32, frontendNodePort int32) *VisitorsApp {
+		return &VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: VisitorsAppSpec{
+				Backend: BackendSpec{
+					TierSpec: TierSpec{
+						Service: ServiceSpec{NodePort: backendNodePort},
+					},
+				},
+				Frontend: FrontendSpec{
+					TierSpec: TierSpec{
+						Service: ServiceSpec{NodePort: frontendNodePort},
+					},
+				},
+			},
+		}
+	}
+
+	It("fills in the defaults", func() {
+		app := newApp("defaulted", 0, 0)
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		created := &VisitorsApp{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "defaulted", Namespace: "default"}, created)).To(Succeed())
+		Expect(created.Spec.Backend.Size).To(Equal(int32(DefaultSize)))
+		Expect(created.Spec.Frontend.Size).To(Equal(int32(DefaultSize)))
+		Expect(created.Spec.Frontend.Title).To(Equal(DefaultFrontendTitle))
+		Expect(created.Spec.Backend.Image.Repository).To(Equal(DefaultBackendImageRepository))
+		Expect(created.Spec.Backend.Image.PullPolicy).To(Equal(corev1.PullAlways))
+		Expect(created.Spec.Frontend.Image.Tag).To(Equal(DefaultFrontendImageTag))
+		Expect(created.Spec.Frontend.Service.Type).To(Equal(corev1.ServiceTypeNodePort))
+		Expect(created.Spec.Backend.Resources.Requests.Cpu().String()).To(Equal(DefaultBackendCPURequest))
+		Expect(created.Spec.Frontend.Resources.Requests.Cpu().String()).To(Equal(DefaultFrontendCPURequest))
+		Expect(created.Spec.DeletionPolicy).To(Equal(DeletionPolicyDelete))
+		Expect(created.Spec.Backend.RolloutStrategy.Type).To(Equal(RolloutStrategyRollingUpdate))
+	})
+
+	It("rejects the same node port for both tiers", func() {
+		err := k8sClient.Create(ctx, newApp("same-ports", 30100, 30100))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects node ports used by another VisitorsApp", func() {
+		Expect(k8sClient.Create(ctx, newApp("first", 30200, 30201))).To(Succeed())
+
+		err := k8sClient.Create(ctx, newApp("second", 30202, 30201))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects auto-scaling without bounds", func() {
+		app := newApp("unbounded", 0, 0)
+		app.Spec.Backend.AutoScaling.Enabled = true
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		minReplicas := int32(3)
+		app.Spec.Backend.AutoScaling.HorizontalPodAutoscaler = &AutoScalerSpec{MinReplicas: &minReplicas, MaxReplicas: 2}
+		err = k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects requests above the limits", func() {
+		app := newApp("overcommitted", 0, 0)
+		app.Spec.Backend.Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		}
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects dropping a database that is not managed", func() {
+		app := newApp("unmanaged-drop", 0, 0)
+		app.Spec.DeletionPolicy = DeletionPolicyRetain
+		app.Spec.Database.DropOnDelete = true
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects scheduled backups with an invalid schedule or target", func() {
+		app := newApp("bad-schedule", 0, 0)
+		app.Spec.Backup = &ScheduledBackupSpec{
+			Schedule: "every night",
+			Target:   BackupTarget{PersistentVolumeClaim: &PersistentVolumeClaimTarget{ClaimName: "backups"}},
+		}
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		app.Spec.Backup.Schedule = "0 3 * * *"
+		app.Spec.Backup.Target = BackupTarget{}
+		err = k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("keeps the last scheduled backups by default", func() {
+		app := newApp("scheduled", 0, 0)
+		app.Spec.Backup = &ScheduledBackupSpec{
+			Schedule: "0 3 * * *",
+			Target:   BackupTarget{PersistentVolumeClaim: &PersistentVolumeClaimTarget{ClaimName: "backups"}},
+		}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		Expect(app.Spec.Backup.Retention.KeepLast).NotTo(BeNil())
+		Expect(*app.Spec.Backup.Retention.KeepLast).To(Equal(int32(DefaultBackupKeepLast)))
+	})
+
+	It("rejects canary rollouts without steps or with decreasing weights", func() {
+		app := newApp("canary", 0, 0)
+		app.Spec.Backend.RolloutStrategy = RolloutStrategy{Type: RolloutStrategyCanary}
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+
+		app.Spec.Backend.RolloutStrategy.Canary = &CanaryStrategy{Steps: []CanaryStep{{Weight: 50}, {Weight: 20}}}
+		err = k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+
+	It("rejects canary rollouts of an auto-scaled backend", func() {
+		app := newApp("canary-scaled", 0, 0)
+		app.Spec.Backend.RolloutStrategy = RolloutStrategy{
+			Type:   RolloutStrategyCanary,
+			Canary: &CanaryStrategy{Steps: []CanaryStep{{Weight: 25}}},
+		}
+		app.Spec.Backend.AutoScaling = AutoScalingSpec{
+			Enabled:                 true,
+			HorizontalPodAutoscaler: &AutoScalerSpec{MaxReplicas: 10},
+		}
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("spec.backend.rolloutStrategy.type"))
+	})
+
+	It("rejects changes to the managed database", func() {
+		app := newApp("managed", 0, 0)
+		app.Spec.Database.Managed = true
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		app.Spec.Database.ClusterName = "renamed"
+		err := k8sClient.Update(ctx, app)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+	})
+})
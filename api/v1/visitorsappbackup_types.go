@@ -0,0 +1,179 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupTarget is where a database dump is stored. Exactly one of its
+// sections has to be set.
+type BackupTarget struct {
+	// PersistentVolumeClaim stores the dump on a volume of the namespace.
+	//+optional
+	PersistentVolumeClaim *PersistentVolumeClaimTarget `json:"persistentVolumeClaim,omitempty"`
+
+	// S3 stores the dump in a bucket of an S3-compatible object storage.
+	//+optional
+	S3 *S3Target `json:"s3,omitempty"`
+}
+
+// PersistentVolumeClaimTarget stores dumps on a PersistentVolumeClaim
+type PersistentVolumeClaimTarget struct {
+	// ClaimName is the PersistentVolumeClaim, in the namespace of the backup.
+	ClaimName string `json:"claimName"`
+
+	// Path is the directory of the volume the dumps are written to.
+	// Defaults to the root of the volume.
+	//+optional
+	Path string `json:"path,omitempty"`
+}
+
+// S3Target stores dumps in a bucket of an S3-compatible object storage
+type S3Target struct {
+	// Endpoint is the URL of the object storage, e.g. "https://s3.amazonaws.com"
+	// or "http://minio.minio:9000".
+	Endpoint string `json:"endpoint"`
+
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to the object names of the dumps.
+	//+optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// CredentialsSecret is a Secret of the namespace holding the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys.
+	CredentialsSecret string `json:"credentialsSecret"`
+
+	// Image of the client copying the dumps, which needs the MinIO client
+	// "mc". Defaults to "minio/mc".
+	//+optional
+	Image string `json:"image,omitempty"`
+}
+
+// VisitorsAppBackupSpec defines the desired state of VisitorsAppBackup
+type VisitorsAppBackupSpec struct {
+	// VisitorsAppName is the VisitorsApp of the namespace whose database is
+	// dumped and whose spec is recorded.
+	VisitorsAppName string `json:"visitorsAppName"`
+
+	// Target is where the dump is stored.
+	Target BackupTarget `json:"target"`
+
+	// Image of the Job dumping the database, which needs mysqldump.
+	// Defaults to "mysql:<mysqlVersion>".
+	//+optional
+	Image string `json:"image,omitempty"`
+
+	// ActiveDeadlineSeconds is how long the Job dumping the database, and
+	// the Job of a restore loading the dump, may run before they fail.
+	// Defaults to 3600.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// DeletionPolicy decides whether the dump is deleted with the backup.
+	// Defaults to Retain.
+	//+optional
+	DeletionPolicy BackupDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// BackupDeletionPolicy decides what happens to the dump of a deleted backup
+// +kubebuilder:validation:Enum=Retain;Delete
+type BackupDeletionPolicy string
+
+const (
+	// BackupDeletionPolicyRetain keeps the dump on its target.
+	BackupDeletionPolicyRetain BackupDeletionPolicy = "Retain"
+	// BackupDeletionPolicyDelete removes the dump from its target, through a
+	// Job run before the backup goes away.
+	BackupDeletionPolicyDelete BackupDeletionPolicy = "Delete"
+)
+
+// BackupPhase is the stage a backup or a restore is at
+// +kubebuilder:validation:Enum=Pending;Running;Completed;Failed
+type BackupPhase string
+
+const (
+	// BackupPhasePending waits for what the backup or restore needs to exist.
+	BackupPhasePending BackupPhase = "Pending"
+	// BackupPhaseRunning runs the Job copying the database.
+	BackupPhaseRunning BackupPhase = "Running"
+	// BackupPhaseCompleted is final, the database was copied.
+	BackupPhaseCompleted BackupPhase = "Completed"
+	// BackupPhaseFailed is final, the Job copying the database failed.
+	BackupPhaseFailed BackupPhase = "Failed"
+)
+
+// VisitorsAppBackupStatus defines the observed state of VisitorsAppBackup
+type VisitorsAppBackupStatus struct {
+	//+optional
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// Message explains the phase.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	//+optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	//+optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Location of the dump, such as "pvc://backups/visitors/daily.sql.gz" or
+	// "s3://backups/visitors/daily.sql.gz".
+	//+optional
+	Location string `json:"location,omitempty"`
+
+	// MysqlVersion of the database that was dumped.
+	//+optional
+	MysqlVersion string `json:"mysqlVersion,omitempty"`
+
+	// VisitorsAppSpec is the spec of the VisitorsApp when it was backed up.
+	//+optional
+	VisitorsAppSpec *VisitorsAppSpec `json:"visitorsAppSpec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="VisitorsApp",type=string,JSONPath=`.spec.visitorsAppName`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Location",type=string,JSONPath=`.status.location`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VisitorsAppBackup is a one-off backup of the database and the spec of a
+// VisitorsApp. Changes to its spec after it started are ignored.
+type VisitorsAppBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VisitorsAppBackupSpec   `json:"spec,omitempty"`
+	Status VisitorsAppBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VisitorsAppBackupList contains a list of VisitorsAppBackup
+type VisitorsAppBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VisitorsAppBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VisitorsAppBackup{}, &VisitorsAppBackupList{})
+}
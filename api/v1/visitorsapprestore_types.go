@@ -0,0 +1,85 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VisitorsAppRestoreSpec defines the desired state of VisitorsAppRestore
+type VisitorsAppRestoreSpec struct {
+	// BackupName is the completed VisitorsAppBackup of the namespace to restore.
+	BackupName string `json:"backupName"`
+
+	// VisitorsAppName is the VisitorsApp the backup is restored into. It is
+	// created with the spec recorded by the backup when it does not exist,
+	// an existing one keeps its spec. Defaults to the VisitorsApp that was
+	// backed up.
+	//+optional
+	VisitorsAppName string `json:"visitorsAppName,omitempty"`
+
+	// Image of the Job loading the dump, which needs the mysql client.
+	// Defaults to "mysql:<mysqlVersion>".
+	//+optional
+	Image string `json:"image,omitempty"`
+}
+
+// VisitorsAppRestoreStatus defines the observed state of VisitorsAppRestore
+type VisitorsAppRestoreStatus struct {
+	//+optional
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// Message explains the phase.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	//+optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	//+optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Backup",type=string,JSONPath=`.spec.backupName`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VisitorsAppRestore loads a VisitorsAppBackup into a VisitorsApp, which is
+// recreated first when it does not exist. Changes to its spec after it
+// started are ignored.
+type VisitorsAppRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VisitorsAppRestoreSpec   `json:"spec,omitempty"`
+	Status VisitorsAppRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VisitorsAppRestoreList contains a list of VisitorsAppRestore
+type VisitorsAppRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VisitorsAppRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VisitorsAppRestore{}, &VisitorsAppRestoreList{})
+}
@@ -15,8 +15,8 @@ limitations under the License.
 */
 
 // Package v1beta1 contains API Schema definitions for the example.com v1beta1 API group
-//+kubebuilder:object:generate=true
-//+groupName=example.com.my.domain
+// +kubebuilder:object:generate=true
+// +groupName=example.com.my.domain
 package v1beta1
 
 import (
@@ -0,0 +1,305 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/ringdrx/visitors-operator/api/v1"
+)
+
+// ConversionDataAnnotation keeps the fields of a v1 VisitorsApp that v1beta1
+// has no place for, so they survive a read and write through v1beta1.
+const ConversionDataAnnotation = "example.com.my.domain/conversion-data"
+
+// The v1 fields stored in the ConversionDataAnnotation
+type conversionData struct {
+	BackendResources  corev1.ResourceRequirements `json:"backendResources,omitempty"`
+	FrontendResources corev1.ResourceRequirements `json:"frontendResources,omitempty"`
+
+	BackendStartupProbe    *corev1.Probe `json:"backendStartupProbe,omitempty"`
+	BackendLivenessProbe   *corev1.Probe `json:"backendLivenessProbe,omitempty"`
+	BackendReadinessProbe  *corev1.Probe `json:"backendReadinessProbe,omitempty"`
+	FrontendStartupProbe   *corev1.Probe `json:"frontendStartupProbe,omitempty"`
+	FrontendLivenessProbe  *corev1.Probe `json:"frontendLivenessProbe,omitempty"`
+	FrontendReadinessProbe *corev1.Probe `json:"frontendReadinessProbe,omitempty"`
+
+	DeletionPolicy          v1.DeletionPolicy `json:"deletionPolicy,omitempty"`
+	DatabaseDropOnDelete    bool              `json:"databaseDropOnDelete,omitempty"`
+	DatabaseSchema          *v1.SchemaSpec    `json:"databaseSchema,omitempty"`
+	DatabaseRootPasswordKey string            `json:"databaseRootPasswordKey,omitempty"`
+
+	Backup *v1.ScheduledBackupSpec `json:"backup,omitempty"`
+
+	BackendRolloutStrategy v1.RolloutStrategy `json:"backendRolloutStrategy,omitempty"`
+
+	BackendDisableAutoRollback  bool `json:"backendDisableAutoRollback,omitempty"`
+	FrontendDisableAutoRollback bool `json:"frontendDisableAutoRollback,omitempty"`
+
+	Status conversionStatusData `json:"status,omitempty"`
+}
+
+// The v1 status fields stored in the ConversionDataAnnotation
+type conversionStatusData struct {
+	Phase v1.VisitorsAppPhase `json:"phase,omitempty"`
+
+	BackendUpdatedReplicas  int32 `json:"backendUpdatedReplicas,omitempty"`
+	FrontendUpdatedReplicas int32 `json:"frontendUpdatedReplicas,omitempty"`
+
+	BackendRolledBack  *v1.RollbackStatus `json:"backendRolledBack,omitempty"`
+	FrontendRolledBack *v1.RollbackStatus `json:"frontendRolledBack,omitempty"`
+
+	SchemaVersion           string            `json:"schemaVersion,omitempty"`
+	BackendRollout          *v1.RolloutStatus `json:"backendRollout,omitempty"`
+	LastScheduledBackupTime *metav1.Time      `json:"lastScheduledBackupTime,omitempty"`
+}
+
+var _ conversion.Convertible = &VisitorsApp{}
+
+// ConvertTo converts this VisitorsApp to the Hub version (v1).
+func (src *VisitorsApp) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.VisitorsApp)
+	src = src.DeepCopy()
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = v1.VisitorsAppSpec{
+		Backend: v1.BackendSpec{
+			TierSpec: v1.TierSpec{
+				Size:        src.Spec.BackendSize,
+				Image:       v1.ImageSpec(src.Spec.BackendImage),
+				Service:     convertServiceTo(src.Spec.BackendService, src.Spec.BackendServiceNodePort),
+				AutoScaling: convertAutoScalingTo(src.Spec.BackendAutoScaling, src.Spec.BackendAutoScaler),
+			},
+		},
+		Frontend: v1.FrontendSpec{
+			TierSpec: v1.TierSpec{
+				Size:        src.Spec.FrontendSize,
+				Image:       v1.ImageSpec(src.Spec.FrontendImage),
+				Service:     convertServiceTo(src.Spec.FrontendService, src.Spec.FrontendServiceNodePort),
+				AutoScaling: convertAutoScalingTo(src.Spec.FrontendAutoScaling, src.Spec.FrontendAutoScaler),
+			},
+			Title: src.Spec.FrontendTitle,
+		},
+		Database: v1.DatabaseSpec{
+			Managed:       src.Spec.Database.Managed,
+			Size:          src.Spec.Database.Replicas,
+			MysqlVersion:  src.Spec.Database.MysqlVersion,
+			Resources:     src.Spec.Database.Resources,
+			ClusterName:   src.Spec.Database.ClusterName,
+			Namespace:     src.Spec.Database.Namespace,
+			SecretName:    src.Spec.Database.SecretName,
+			UserKey:       src.Spec.Database.UserKey,
+			PasswordKey:   src.Spec.Database.PasswordKey,
+			ServiceRWName: src.Spec.Database.ServiceRWName,
+			ServiceROName: src.Spec.Database.ServiceROName,
+			DatabaseName:  src.Spec.Database.DatabaseName,
+		},
+		Ingress: (*v1.IngressSpec)(src.Spec.Ingress),
+	}
+
+	dst.Status = v1.VisitorsAppStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         src.Status.Conditions,
+		Backend: v1.TierStatus{
+			Image:         src.Status.BackendImage,
+			Replicas:      src.Status.BackendReplicas,
+			ReadyReplicas: src.Status.BackendReadyReplicas,
+			URL:           src.Status.BackendURL,
+		},
+		Frontend: v1.TierStatus{
+			Image:         src.Status.FrontendImage,
+			Replicas:      src.Status.FrontendReplicas,
+			ReadyReplicas: src.Status.FrontendReadyReplicas,
+			URL:           src.Status.FrontendURL,
+		},
+	}
+
+	return restoreConversionData(dst)
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version.
+func (dst *VisitorsApp) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1.VisitorsApp).DeepCopy()
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = VisitorsAppSpec{
+		BackendSize:             src.Spec.Backend.Size,
+		BackendAutoScaling:      src.Spec.Backend.AutoScaling.Enabled,
+		BackendAutoScaler:       (*AutoScalerSpec)(src.Spec.Backend.AutoScaling.HorizontalPodAutoscaler),
+		BackendServiceNodePort:  src.Spec.Backend.Service.NodePort,
+		BackendService:          convertServiceFrom(src.Spec.Backend.Service),
+		BackendImage:            ImageSpec(src.Spec.Backend.Image),
+		FrontendTitle:           src.Spec.Frontend.Title,
+		FrontendSize:            src.Spec.Frontend.Size,
+		FrontendAutoScaling:     src.Spec.Frontend.AutoScaling.Enabled,
+		FrontendAutoScaler:      (*AutoScalerSpec)(src.Spec.Frontend.AutoScaling.HorizontalPodAutoscaler),
+		FrontendServiceNodePort: src.Spec.Frontend.Service.NodePort,
+		FrontendService:         convertServiceFrom(src.Spec.Frontend.Service),
+		FrontendImage:           ImageSpec(src.Spec.Frontend.Image),
+		Database: DatabaseSpec{
+			Managed:       src.Spec.Database.Managed,
+			Replicas:      src.Spec.Database.Size,
+			MysqlVersion:  src.Spec.Database.MysqlVersion,
+			Resources:     src.Spec.Database.Resources,
+			ClusterName:   src.Spec.Database.ClusterName,
+			Namespace:     src.Spec.Database.Namespace,
+			SecretName:    src.Spec.Database.SecretName,
+			UserKey:       src.Spec.Database.UserKey,
+			PasswordKey:   src.Spec.Database.PasswordKey,
+			ServiceRWName: src.Spec.Database.ServiceRWName,
+			ServiceROName: src.Spec.Database.ServiceROName,
+			DatabaseName:  src.Spec.Database.DatabaseName,
+		},
+		Ingress: (*IngressSpec)(src.Spec.Ingress),
+	}
+
+	dst.Status = VisitorsAppStatus{
+		BackendImage:          src.Status.Backend.Image,
+		FrontendImage:         src.Status.Frontend.Image,
+		ObservedGeneration:    src.Status.ObservedGeneration,
+		Conditions:            src.Status.Conditions,
+		BackendReplicas:       src.Status.Backend.Replicas,
+		BackendReadyReplicas:  src.Status.Backend.ReadyReplicas,
+		FrontendReplicas:      src.Status.Frontend.Replicas,
+		FrontendReadyReplicas: src.Status.Frontend.ReadyReplicas,
+		BackendURL:            src.Status.Backend.URL,
+		FrontendURL:           src.Status.Frontend.URL,
+	}
+
+	return storeConversionData(src, dst)
+}
+
+func convertServiceTo(spec ServiceSpec, nodePort int32) v1.ServiceSpec {
+	return v1.ServiceSpec{
+		Type:                     spec.Type,
+		NodePort:                 nodePort,
+		Annotations:              spec.Annotations,
+		LoadBalancerSourceRanges: spec.LoadBalancerSourceRanges,
+		ExternalTrafficPolicy:    spec.ExternalTrafficPolicy,
+	}
+}
+
+func convertServiceFrom(spec v1.ServiceSpec) ServiceSpec {
+	return ServiceSpec{
+		Type:                     spec.Type,
+		Annotations:              spec.Annotations,
+		LoadBalancerSourceRanges: spec.LoadBalancerSourceRanges,
+		ExternalTrafficPolicy:    spec.ExternalTrafficPolicy,
+	}
+}
+
+func convertAutoScalingTo(enabled bool, spec *AutoScalerSpec) v1.AutoScalingSpec {
+	return v1.AutoScalingSpec{
+		Enabled:                 enabled,
+		HorizontalPodAutoscaler: (*v1.AutoScalerSpec)(spec),
+	}
+}
+
+// Records the v1 fields lost in the conversion to v1beta1 in an annotation
+func storeConversionData(src *v1.VisitorsApp, dst *VisitorsApp) error {
+	data := conversionData{
+		BackendResources:        src.Spec.Backend.Resources,
+		FrontendResources:       src.Spec.Frontend.Resources,
+		BackendStartupProbe:     src.Spec.Backend.StartupProbe,
+		BackendLivenessProbe:    src.Spec.Backend.LivenessProbe,
+		BackendReadinessProbe:   src.Spec.Backend.ReadinessProbe,
+		FrontendStartupProbe:    src.Spec.Frontend.StartupProbe,
+		FrontendLivenessProbe:   src.Spec.Frontend.LivenessProbe,
+		FrontendReadinessProbe:  src.Spec.Frontend.ReadinessProbe,
+		DeletionPolicy:          src.Spec.DeletionPolicy,
+		DatabaseDropOnDelete:    src.Spec.Database.DropOnDelete,
+		DatabaseSchema:          src.Spec.Database.Schema,
+		DatabaseRootPasswordKey: src.Spec.Database.RootPasswordKey,
+		Backup:                  src.Spec.Backup,
+		BackendRolloutStrategy:  src.Spec.Backend.RolloutStrategy,
+
+		BackendDisableAutoRollback:  src.Spec.Backend.DisableAutoRollback,
+		FrontendDisableAutoRollback: src.Spec.Frontend.DisableAutoRollback,
+
+		Status: conversionStatusData{
+			Phase:                   src.Status.Phase,
+			BackendUpdatedReplicas:  src.Status.Backend.UpdatedReplicas,
+			FrontendUpdatedReplicas: src.Status.Frontend.UpdatedReplicas,
+			BackendRolledBack:       src.Status.Backend.RolledBack,
+			FrontendRolledBack:      src.Status.Frontend.RolledBack,
+			SchemaVersion:           src.Status.SchemaVersion,
+			BackendRollout:          src.Status.BackendRollout,
+			LastScheduledBackupTime: src.Status.LastScheduledBackupTime,
+		},
+	}
+	if equality.Semantic.DeepEqual(data, conversionData{}) {
+		return nil
+	}
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[ConversionDataAnnotation] = string(content)
+	return nil
+}
+
+// Puts back the v1 fields recorded by storeConversionData
+func restoreConversionData(dst *v1.VisitorsApp) error {
+	content, ok := dst.Annotations[ConversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(dst.Annotations, ConversionDataAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+
+	data := conversionData{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return err
+	}
+	dst.Spec.Backend.Resources = data.BackendResources
+	dst.Spec.Frontend.Resources = data.FrontendResources
+	dst.Spec.Backend.StartupProbe = data.BackendStartupProbe
+	dst.Spec.Backend.LivenessProbe = data.BackendLivenessProbe
+	dst.Spec.Backend.ReadinessProbe = data.BackendReadinessProbe
+	dst.Spec.Frontend.StartupProbe = data.FrontendStartupProbe
+	dst.Spec.Frontend.LivenessProbe = data.FrontendLivenessProbe
+	dst.Spec.Frontend.ReadinessProbe = data.FrontendReadinessProbe
+	dst.Spec.DeletionPolicy = data.DeletionPolicy
+	dst.Spec.Database.DropOnDelete = data.DatabaseDropOnDelete
+	dst.Spec.Database.Schema = data.DatabaseSchema
+	dst.Spec.Database.RootPasswordKey = data.DatabaseRootPasswordKey
+	dst.Spec.Backup = data.Backup
+	dst.Spec.Backend.RolloutStrategy = data.BackendRolloutStrategy
+	dst.Spec.Backend.DisableAutoRollback = data.BackendDisableAutoRollback
+	dst.Spec.Frontend.DisableAutoRollback = data.FrontendDisableAutoRollback
+
+	dst.Status.Phase = data.Status.Phase
+	dst.Status.Backend.UpdatedReplicas = data.Status.BackendUpdatedReplicas
+	dst.Status.Frontend.UpdatedReplicas = data.Status.FrontendUpdatedReplicas
+	dst.Status.Backend.RolledBack = data.Status.BackendRolledBack
+	dst.Status.Frontend.RolledBack = data.Status.FrontendRolledBack
+	dst.Status.SchemaVersion = data.Status.SchemaVersion
+	dst.Status.BackendRollout = data.Status.BackendRollout
+	dst.Status.LastScheduledBackupTime = data.Status.LastScheduledBackupTime
+	return nil
+}
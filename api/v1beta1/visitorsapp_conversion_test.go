@@ -0,0 +1,264 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1 "github.com/ringdrx/visitors-operator/api/v1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestVisitorsAppRoundTripFromV1beta1(t *testing.T) {
+	className := "nginx"
+	stabilizationWindow := int32(300)
+	src := &VisitorsApp{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "visitorsapp-sample",
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "visitors"},
+			Annotations: map[string]string{"note": "kept"},
+		},
+		Spec: VisitorsAppSpec{
+			BackendSize:            2,
+			BackendAutoScaling:     true,
+			BackendServiceNodePort: 30685,
+			BackendService: ServiceSpec{
+				Type:                  corev1.ServiceTypeLoadBalancer,
+				Annotations:           map[string]string{"lb": "internal"},
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+				LoadBalancerSourceRanges: []string{
+					"10.0.0.0/8",
+				},
+			},
+			BackendImage: ImageSpec{
+				Repository: "kerryduan/visitors-service",
+				Tag:        "1.0.0",
+				PullPolicy: corev1.PullAlways,
+			},
+			FrontendTitle: "Visitors",
+			FrontendSize:  3,
+			FrontendAutoScaler: &AutoScalerSpec{
+				MinReplicas:                    int32Ptr(1),
+				MaxReplicas:                    5,
+				TargetCPUUtilizationPercentage: int32Ptr(80),
+				Behavior: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+					ScaleDown: &autoscalingv2.HPAScalingRules{
+						StabilizationWindowSeconds: &stabilizationWindow,
+					},
+				},
+			},
+			FrontendServiceNodePort: 30686,
+			FrontendImage: ImageSpec{
+				Repository: "jdob/visitors-webui",
+				Digest:     "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			Database: DatabaseSpec{
+				Managed:      true,
+				Replicas:     int32Ptr(2),
+				MysqlVersion: "5.7.31",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				},
+				UserKey:      "USER",
+				DatabaseName: "visitors_db",
+			},
+			Ingress: &IngressSpec{
+				Host:             "visitors.example.com",
+				Path:             "/visitors",
+				IngressClassName: &className,
+				TLSSecretName:    "visitors-tls",
+			},
+		},
+		Status: VisitorsAppStatus{
+			BackendImage:       "kerryduan/visitors-service:1.0.0",
+			ObservedGeneration: 4,
+			Conditions: []metav1.Condition{{
+				Type:   ConditionReady,
+				Status: metav1.ConditionTrue,
+				Reason: "AllComponentsReady",
+			}},
+			BackendReplicas:       2,
+			BackendReadyReplicas:  2,
+			FrontendReplicas:      3,
+			FrontendReadyReplicas: 1,
+			FrontendURL:           "https://visitors.example.com/visitors",
+		},
+	}
+
+	hub := &v1.VisitorsApp{}
+	if err := src.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if hub.Spec.Frontend.AutoScaling.HorizontalPodAutoscaler.MaxReplicas != 5 {
+		t.Errorf("frontend autoscaler not converted: %+v", hub.Spec.Frontend.AutoScaling)
+	}
+	if hub.Spec.Backend.Service.NodePort != 30685 {
+		t.Errorf("backend node port not converted: %+v", hub.Spec.Backend.Service)
+	}
+
+	dst := &VisitorsApp{}
+	if err := dst.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(src, dst) {
+		t.Errorf("v1beta1 object changed in the round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+	}
+}
+
+func TestVisitorsAppRoundTripFromV1(t *testing.T) {
+	src := &v1.VisitorsApp{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "visitorsapp-sample",
+			Namespace: "default",
+		},
+		Spec: v1.VisitorsAppSpec{
+			Backend: v1.BackendSpec{
+				TierSpec: v1.TierSpec{
+					Size: 2,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+						Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+					},
+					Service: v1.ServiceSpec{
+						Type:     corev1.ServiceTypeNodePort,
+						NodePort: 30685,
+					},
+					AutoScaling: v1.AutoScalingSpec{
+						Enabled: true,
+						HorizontalPodAutoscaler: &v1.AutoScalerSpec{
+							MaxReplicas: 4,
+						},
+					},
+				},
+				RolloutStrategy: v1.RolloutStrategy{
+					Type: v1.RolloutStrategyCanary,
+					Canary: &v1.CanaryStrategy{
+						Steps: []v1.CanaryStep{{Weight: 25, Pause: metav1.Duration{Duration: time.Minute}}},
+					},
+				},
+			},
+			Frontend: v1.FrontendSpec{
+				TierSpec: v1.TierSpec{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+					},
+					ReadinessProbe: &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{
+							HTTPGet: &corev1.HTTPGetAction{
+								Path: "/healthz",
+								Port: intstr.FromInt(3000),
+							},
+						},
+						PeriodSeconds: 15,
+					},
+					DisableAutoRollback: true,
+				},
+				Title: "Visitors",
+			},
+			Database: v1.DatabaseSpec{
+				Managed:      true,
+				Size:         int32Ptr(3),
+				DropOnDelete: true,
+				Schema: &v1.SchemaSpec{
+					MigrationsConfigMap: "visitors-migrations",
+				},
+				RootPasswordKey: "root-password",
+			},
+			DeletionPolicy: v1.DeletionPolicyRetain,
+			Backup: &v1.ScheduledBackupSpec{
+				Schedule: "0 3 * * *",
+				Target: v1.BackupTarget{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimTarget{ClaimName: "visitors-backups"},
+				},
+				Retention: v1.BackupRetention{KeepLast: int32Ptr(7)},
+			},
+		},
+		Status: v1.VisitorsAppStatus{
+			Phase: v1.VisitorsAppPhaseRollingOutBackend,
+			Backend: v1.TierStatus{
+				Image:           "kerryduan/visitors-service:1.0.0",
+				Replicas:        2,
+				ReadyReplicas:   1,
+				UpdatedReplicas: 1,
+				URL:             "http://10.0.0.1:30685",
+			},
+			Frontend: v1.TierStatus{
+				RolledBack: &v1.RollbackStatus{
+					TemplateHash: "0123456789abcdef",
+					Message:      "container frontend of pod visitors-frontend-0 is in CrashLoopBackOff",
+					Time:         metav1.NewTime(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)),
+				},
+			},
+			SchemaVersion: "0002",
+			BackendRollout: &v1.RolloutStatus{
+				Strategy:      v1.RolloutStrategyCanary,
+				Phase:         v1.RolloutPhasePaused,
+				TemplateHash:  "fedcba9876543210",
+				Step:          1,
+				Weight:        25,
+				StepStartTime: &metav1.Time{Time: time.Date(2021, 6, 1, 12, 5, 0, 0, time.UTC)},
+			},
+			LastScheduledBackupTime: &metav1.Time{Time: time.Date(2021, 6, 1, 3, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	spoke := &VisitorsApp{}
+	if err := spoke.ConvertFrom(src.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if _, ok := spoke.Annotations[ConversionDataAnnotation]; !ok {
+		t.Errorf("v1 only fields not stored in the %s annotation", ConversionDataAnnotation)
+	}
+
+	dst := &v1.VisitorsApp{}
+	if err := spoke.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(src, dst) {
+		t.Errorf("v1 object changed in the round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+	}
+}
+
+func TestVisitorsAppConversionWithoutV1OnlyFields(t *testing.T) {
+	src := &v1.VisitorsApp{
+		ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"},
+		Spec: v1.VisitorsAppSpec{
+			Backend: v1.BackendSpec{TierSpec: v1.TierSpec{Size: 1}},
+		},
+	}
+
+	spoke := &VisitorsApp{}
+	if err := spoke.ConvertFrom(src); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if spoke.Annotations != nil {
+		t.Errorf("unexpected annotations: %v", spoke.Annotations)
+	}
+}
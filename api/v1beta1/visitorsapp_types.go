@@ -17,6 +17,8 @@ limitations under the License.
 package v1beta1
 
 import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,44 +27,297 @@ import (
 
 //DatabaseSize int32 `json:"databaseSize"`
 
+// ImageSpec selects the container image of a tier. Unset fields fall back to
+// the operator's built-in defaults for that tier.
+type ImageSpec struct {
+	// Repository is the image name without tag or digest,
+	// e.g. "kerryduan/visitors-service".
+	//+optional
+	Repository string `json:"repository,omitempty"`
+
+	//+optional
+	Tag string `json:"tag,omitempty"`
+
+	// Digest pins the image by content and takes precedence over Tag.
+	//+kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	//+optional
+	Digest string `json:"digest,omitempty"`
+
+	//+kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	//+optional
+	PullPolicy corev1.PullPolicy `json:"pullPolicy,omitempty"`
+}
+
+// AutoScalerSpec configures the HorizontalPodAutoscaler the operator manages for a tier.
+type AutoScalerSpec struct {
+	// MinReplicas defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the average CPU utilization, relative
+	// to the CPU requests, the autoscaler aims for.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// TargetMemoryUtilizationPercentage is the average memory utilization,
+	// relative to the memory requests, the autoscaler aims for.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+
+	// Metrics are used in addition to the CPU and memory targets, e.g. custom
+	// metrics served by the prometheus adapter.
+	//+optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+
+	// Behavior configures the scaling policies in both directions.
+	//+optional
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// ServiceSpec configures how the Service of a tier is exposed.
+type ServiceSpec struct {
+	// Type of the Service. Defaults to NodePort.
+	//+kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	//+optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations added to the Service, e.g. to configure a cloud load balancer.
+	//+optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// LoadBalancerSourceRanges restricts the clients of a LoadBalancer Service.
+	//+optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+
+	// ExternalTrafficPolicy of a NodePort or LoadBalancer Service.
+	//+kubebuilder:validation:Enum=Cluster;Local
+	//+optional
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+}
+
+// IngressSpec configures the Ingress the operator manages in front of both tiers.
+type IngressSpec struct {
+	// Host the Ingress serves. All hosts are matched when omitted.
+	//+optional
+	Host string `json:"host,omitempty"`
+
+	// Path the frontend is served at. The backend is served at "<path>/api".
+	// Defaults to "/".
+	//+kubebuilder:validation:Pattern=`^/`
+	//+optional
+	Path string `json:"path,omitempty"`
+
+	//+optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// TLSSecretName enables TLS for the host with the certificate in this Secret.
+	//+optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// Annotations added to the Ingress, e.g. to configure the ingress controller.
+	//+optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// DatabaseSpec references the presslabs MysqlCluster the backend connects to.
+// Unset fields fall back to the names used by the samples in config/samples/mysql.
+type DatabaseSpec struct {
+	// Managed makes the operator create and own the MysqlCluster and its
+	// credentials Secret, instead of waiting for them to be applied by hand.
+	//+optional
+	Managed bool `json:"managed,omitempty"`
+
+	// Replicas of the managed MysqlCluster. Defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	//+optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// MysqlVersion of the managed MysqlCluster. Defaults to "5.7.31".
+	//+optional
+	MysqlVersion string `json:"mysqlVersion,omitempty"`
+
+	// Resources of the MySQL pods of the managed MysqlCluster.
+	//+optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ClusterName is the name of the MysqlCluster. Defaults to "my-cluster",
+	// or to "<name>-db" when the cluster is managed.
+	//+optional
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Namespace of the MysqlCluster. Defaults to the namespace of the
+	// VisitorsApp, which is also the only namespace a managed cluster can live in.
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SecretName is the Secret holding the application credentials. It is
+	// always read from the namespace of the VisitorsApp, since pods can only
+	// reference Secrets in their own namespace. Defaults to "my-secret", or to
+	// "<name>-db-credentials" when the cluster is managed.
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// UserKey is the key of the user name in the Secret. Defaults to "USER".
+	//+optional
+	UserKey string `json:"userKey,omitempty"`
+
+	// PasswordKey is the key of the password in the Secret. Defaults to "PASSWORD".
+	//+optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+
+	// ServiceRWName is the read-write (master) Service of the cluster.
+	// Defaults to "<clusterName>-mysql-master".
+	//+optional
+	ServiceRWName string `json:"serviceRWName,omitempty"`
+
+	// ServiceROName is the read-only Service of the cluster.
+	// Defaults to "<clusterName>-mysql".
+	//+optional
+	ServiceROName string `json:"serviceROName,omitempty"`
+
+	// DatabaseName is the schema the backend uses. Defaults to "visitors_db".
+	//+optional
+	DatabaseName string `json:"databaseName,omitempty"`
+}
+
 // VisitorsAppSpec defines the desired state of VisitorsApp
-//+k8s:openapi-gen=true
+// +k8s:openapi-gen=true
 type VisitorsAppSpec struct {
+	// BackendSize defaults to 1.
 	//+kubebuilder:validation:Minimum=1
-	BackendSize int32 `json:"backendSize"`
+	//+optional
+	BackendSize int32 `json:"backendSize,omitempty"`
+
+	//+optional
+	BackendAutoScaling bool `json:"backendAutoScaling,omitempty"`
 
-	BackendAutoScaling bool `json:"backendAutoScaling"`
+	// BackendAutoScaler makes the operator manage a HorizontalPodAutoscaler for
+	// the backend. Setting it implies backendAutoScaling.
+	//+optional
+	BackendAutoScaler *AutoScalerSpec `json:"backendAutoScaler,omitempty"`
 
+	// BackendServiceNodePort is the node port of a NodePort or LoadBalancer
+	// Service. It is allocated automatically when omitted.
 	//+kubebuilder:validation:Minimum=30000
 	//+kubebuilder:validation:Maximum=32767
-	BackendServiceNodePort int32 `json:"backendServiceNodePort"`
+	//+optional
+	BackendServiceNodePort int32 `json:"backendServiceNodePort,omitempty"`
 
-	FrontendTitle string `json:"frontendTitle"`
+	//+optional
+	BackendService ServiceSpec `json:"backendService,omitempty"`
 
+	//+optional
+	BackendImage ImageSpec `json:"backendImage,omitempty"`
+
+	// FrontendTitle defaults to "Visitors Site".
+	//+optional
+	FrontendTitle string `json:"frontendTitle,omitempty"`
+
+	// FrontendSize defaults to 1.
 	//+kubebuilder:validation:Minimum=1
-	FrontendSize int32 `json:"frontendSize"`
+	//+optional
+	FrontendSize int32 `json:"frontendSize,omitempty"`
+
+	//+optional
+	FrontendAutoScaling bool `json:"frontendAutoScaling,omitempty"`
 
-	FrontendAutoScaling bool `json:"frontendAutoScaling"`
+	// FrontendAutoScaler makes the operator manage a HorizontalPodAutoscaler for
+	// the frontend. Setting it implies frontendAutoScaling.
+	//+optional
+	FrontendAutoScaler *AutoScalerSpec `json:"frontendAutoScaler,omitempty"`
 
+	// FrontendServiceNodePort is the node port of a NodePort or LoadBalancer
+	// Service. It is allocated automatically when omitted.
 	//+kubebuilder:validation:Minimum=30000
 	//+kubebuilder:validation:Maximum=32767
-	FrontendServiceNodePort int32 `json:"frontendServiceNodePort"`
+	//+optional
+	FrontendServiceNodePort int32 `json:"frontendServiceNodePort,omitempty"`
+
+	//+optional
+	FrontendService ServiceSpec `json:"frontendService,omitempty"`
+
+	//+optional
+	FrontendImage ImageSpec `json:"frontendImage,omitempty"`
+
+	//+optional
+	Database DatabaseSpec `json:"database,omitempty"`
+
+	// Ingress makes the operator manage an Ingress routing to the frontend and
+	// the backend. The Ingress is deleted when the section is removed.
+	//+optional
+	Ingress *IngressSpec `json:"ingress,omitempty"`
 }
 
+// Condition types reported in the status of a VisitorsApp
+const (
+	// ConditionDatabaseReady tells whether the MySQL cluster accepts connections.
+	ConditionDatabaseReady = "DatabaseReady"
+	// ConditionBackendAvailable mirrors the Available condition of the backend Deployment.
+	ConditionBackendAvailable = "BackendAvailable"
+	// ConditionFrontendAvailable mirrors the Available condition of the frontend Deployment.
+	ConditionFrontendAvailable = "FrontendAvailable"
+	// ConditionReady is True when all of the above are True.
+	ConditionReady = "Ready"
+)
+
 // VisitorsAppStatus defines the observed state of VisitorsApp
-//+k8s:openapi-gen=true
+// +k8s:openapi-gen=true
 type VisitorsAppStatus struct {
 	BackendImage  string `json:"backendImage,omitempty"`
 	FrontendImage string `json:"frontendImage,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	//+listType=map
+	//+listMapKey=type
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// BackendReplicas is the desired number of backend pods.
+	//+optional
+	BackendReplicas int32 `json:"backendReplicas,omitempty"`
+
+	//+optional
+	BackendReadyReplicas int32 `json:"backendReadyReplicas,omitempty"`
+
+	// FrontendReplicas is the desired number of frontend pods.
+	//+optional
+	FrontendReplicas int32 `json:"frontendReplicas,omitempty"`
+
+	//+optional
+	FrontendReadyReplicas int32 `json:"frontendReadyReplicas,omitempty"`
+
+	// BackendURL is the address the backend Service can be reached at.
+	//+optional
+	BackendURL string `json:"backendURL,omitempty"`
+
+	// FrontendURL is the address the frontend Service can be reached at.
+	//+optional
+	FrontendURL string `json:"frontendURL,omitempty"`
 }
 
 //+k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Backend",type=integer,JSONPath=`.status.backendReadyReplicas`,description="Ready backend pods"
+//+kubebuilder:printcolumn:name="Backend Desired",type=integer,JSONPath=`.status.backendReplicas`,priority=1
+//+kubebuilder:printcolumn:name="Frontend",type=integer,JSONPath=`.status.frontendReadyReplicas`,description="Ready frontend pods"
+//+kubebuilder:printcolumn:name="Frontend Desired",type=integer,JSONPath=`.status.frontendReplicas`,priority=1
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.frontendURL`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // VisitorsApp is the Schema for the visitorsapps API
-//+k8s:openapi-gen=true
-//+kubebuilder:subresource:status
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
 type VisitorsApp struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func newHorizontalPodAutoscaler(name string, namespace string) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: autoscalingv2.SchemeGroupVersion.String(),
+			Kind:       "HorizontalPodAutoscaler",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+// Renders an HPA scaling the given Deployment of a tier
+func (r *VisitorsAppReconciler) horizontalPodAutoscaler(v *examplecomv1.VisitorsApp,
+	tier string,
+	name string,
+	deploymentName string,
+	spec *examplecomv1.AutoScalerSpec,
+) *autoscalingv2.HorizontalPodAutoscaler {
+	metrics := []autoscalingv2.MetricSpec{}
+	if spec.TargetCPUUtilizationPercentage != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceCPU, spec.TargetCPUUtilizationPercentage))
+	}
+	if spec.TargetMemoryUtilizationPercentage != nil {
+		metrics = append(metrics, resourceMetric(corev1.ResourceMemory, spec.TargetMemoryUtilizationPercentage))
+	}
+	metrics = append(metrics, spec.Metrics...)
+
+	hpa := newHorizontalPodAutoscaler(name, v.Namespace)
+	hpa.Labels = labels(v, tier)
+	hpa.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       deploymentName,
+		},
+		MinReplicas: spec.MinReplicas,
+		MaxReplicas: spec.MaxReplicas,
+		Metrics:     metrics,
+		Behavior:    spec.Behavior,
+	}
+
+	controllerutil.SetControllerReference(v, hpa, r.Scheme)
+	return hpa
+}
+
+func resourceMetric(name corev1.ResourceName, utilization *int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: utilization,
+			},
+		},
+	}
+}
+
+// Applies the HPA of a tier, or deletes it once auto-scaling is removed from the spec
+func (r *VisitorsAppReconciler) ensureHorizontalPodAutoscaler(ctx context.Context,
+	v *examplecomv1.VisitorsApp,
+	tier string,
+	name string,
+	deploymentName string,
+	spec *examplecomv1.AutoScalerSpec,
+) (*ctrl.Result, error) {
+	if spec == nil {
+		return r.ensureDeleted(ctx, v, newHorizontalPodAutoscaler(name, v.Namespace))
+	}
+
+	hpa := r.horizontalPodAutoscaler(v, tier, name, deploymentName, spec)
+	return r.ensureApplied(ctx, v, hpa, &autoscalingv2.HorizontalPodAutoscaler{})
+}
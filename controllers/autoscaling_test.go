@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("HorizontalPodAutoscalers", func() {
+	var (
+		ctx  context.Context
+		app  *examplecomv1.VisitorsApp
+		r    *VisitorsAppReconciler
+		spec *examplecomv1.AutoScalerSpec
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		minReplicas, cpu, memory := int32(2), int32(70), int32(80)
+		window := int32(120)
+		spec = &examplecomv1.AutoScalerSpec{
+			MinReplicas:                       &minReplicas,
+			MaxReplicas:                       5,
+			TargetCPUUtilizationPercentage:    &cpu,
+			TargetMemoryUtilizationPercentage: &memory,
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.PodsMetricSourceType,
+				Pods: &autoscalingv2.PodsMetricSource{
+					Metric: autoscalingv2.MetricIdentifier{Name: "http_requests_per_second"},
+					Target: autoscalingv2.MetricTarget{
+						Type:         autoscalingv2.AverageValueMetricType,
+						AverageValue: resource.NewQuantity(100, resource.DecimalSI),
+					},
+				},
+			}},
+			Behavior: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+				ScaleDown: &autoscalingv2.HPAScalingRules{StabilizationWindowSeconds: &window},
+			},
+		}
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "scaled", Namespace: "default", UID: "scaled-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{
+					AutoScaling: examplecomv1.AutoScalingSpec{Enabled: true, HorizontalPodAutoscaler: spec},
+				}},
+			},
+		}
+		r = newTestReconciler(app)
+	})
+
+	ensureBackendHPA := func() {
+		result, err := r.ensureHorizontalPodAutoscaler(ctx, app, "backend",
+			backendHorizontalPodAutoscalerName(app), backendDeploymentName(app), app.Spec.Backend.AutoScaling.HorizontalPodAutoscaler)
+		Expect(result).To(BeNil())
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	getBackendHPA := func() (*autoscalingv2.HorizontalPodAutoscaler, error) {
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+		err := r.Get(ctx, types.NamespacedName{Name: "scaled-backend-hpa", Namespace: app.Namespace}, hpa)
+		return hpa, err
+	}
+
+	It("scales the Deployment of the tier", func() {
+		ensureBackendHPA()
+
+		hpa, err := getBackendHPA()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metav1.IsControlledBy(hpa, app)).To(BeTrue())
+		Expect(hpa.Labels).To(Equal(labels(app, "backend")))
+		Expect(hpa.Spec.ScaleTargetRef).To(Equal(autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       "scaled-backend",
+		}))
+		Expect(*hpa.Spec.MinReplicas).To(Equal(int32(2)))
+		Expect(hpa.Spec.MaxReplicas).To(Equal(int32(5)))
+		Expect(hpa.Spec.Metrics).To(HaveLen(3))
+		Expect(hpa.Spec.Metrics[0].Resource.Name).To(Equal(corev1.ResourceCPU))
+		Expect(*hpa.Spec.Metrics[0].Resource.Target.AverageUtilization).To(Equal(int32(70)))
+		Expect(hpa.Spec.Metrics[1].Resource.Name).To(Equal(corev1.ResourceMemory))
+		Expect(hpa.Spec.Metrics[2].Pods.Metric.Name).To(Equal("http_requests_per_second"))
+		Expect(*hpa.Spec.Behavior.ScaleDown.StabilizationWindowSeconds).To(Equal(int32(120)))
+	})
+
+	It("updates the autoscaler on spec changes and deletes it once removed", func() {
+		ensureBackendHPA()
+
+		spec.MaxReplicas = 8
+		ensureBackendHPA()
+		hpa, err := getBackendHPA()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hpa.Spec.MaxReplicas).To(Equal(int32(8)))
+
+		app.Spec.Backend.AutoScaling = examplecomv1.AutoScalingSpec{}
+		ensureBackendHPA()
+		_, err = getBackendHPA()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -2,57 +2,170 @@ package controllers
 
 import (
 	"context"
-	"time"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 
-	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
-	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const backendPort = 8000
-const backendImage = "kerryduan/visitors-service:1.0.0"
 
-func backendDeploymentName(v *examplecomv1beta1.VisitorsApp) string {
+// Annotation of the backend pod template holding a hash of the database
+// credentials. Environment variables are only read when a container starts, so
+// changing it on rotation rolls the backend pods.
+const credentialsHashAnnotation = "example.com.my.domain/credentials-hash"
+
+func backendDeploymentName(v *examplecomv1.VisitorsApp) string {
 	return v.Name + "-backend"
 }
 
-func backendServiceName(v *examplecomv1beta1.VisitorsApp) string {
+func backendServiceName(v *examplecomv1.VisitorsApp) string {
 	return v.Name + "-backend-service"
 }
 
-func (r *VisitorsAppReconciler) backendDeployment(v *examplecomv1beta1.VisitorsApp) *appsv1.Deployment {
-	labels := labels(v, "backend")
-	backendSize := v.Spec.BackendSize
+func backendHorizontalPodAutoscalerName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-backend-hpa"
+}
+
+// An operator-managed autoscaler implies auto-scaling
+func backendAutoScaling(v *examplecomv1.VisitorsApp) bool {
+	return v.Spec.Backend.AutoScaling.Enabled || v.Spec.Backend.AutoScaling.HorizontalPodAutoscaler != nil
+}
+
+func backendSize(v *examplecomv1.VisitorsApp) int32 {
+	if v.Spec.Backend.Size != 0 {
+		return v.Spec.Backend.Size
+	}
+	return examplecomv1.DefaultSize
+}
+
+func backendImage(v *examplecomv1.VisitorsApp) string {
+	return image(v.Spec.Backend.Image, examplecomv1.DefaultBackendImageRepository, examplecomv1.DefaultBackendImageTag)
+}
+
+func backendImagePullPolicy(v *examplecomv1.VisitorsApp) corev1.PullPolicy {
+	if v.Spec.Backend.Image.PullPolicy != "" {
+		return v.Spec.Backend.Image.PullPolicy
+	}
+	return corev1.PullAlways
+}
+
+func backendResources(v *examplecomv1.VisitorsApp) corev1.ResourceRequirements {
+	return resources(v.Spec.Backend.Resources, examplecomv1.DefaultBackendCPURequest)
+}
 
+// Returns the environment the visitors-service container needs to reach MySQL
+func backendEnv(v *examplecomv1.VisitorsApp) []corev1.EnvVar {
 	userSecret := &corev1.EnvVarSource{
 		SecretKeyRef: &corev1.SecretKeySelector{
-			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName()},
-			Key:                  "USER",
+			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName(v)},
+			Key:                  mysqlUserKey(v),
 		},
 	}
 
 	passwordSecret := &corev1.EnvVarSource{
 		SecretKeyRef: &corev1.SecretKeySelector{
-			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName()},
-			Key:                  "PASSWORD",
+			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName(v)},
+			Key:                  mysqlPasswordKey(v),
 		},
 	}
 
+	return []corev1.EnvVar{
+		{
+			Name:  "MYSQL_DATABASE",
+			Value: mysqlDatabaseName(v),
+		},
+		{
+			Name:  "MYSQL_SERVICE_HOST_RW",
+			Value: mysqlServiceHost(v, mysqlServiceRWName(v)),
+		},
+		{
+			Name:  "MYSQL_SERVICE_HOST_RO",
+			Value: mysqlServiceHost(v, mysqlServiceROName(v)),
+		},
+		{
+			Name:      "MYSQL_USERNAME",
+			ValueFrom: userSecret,
+		},
+		{
+			Name:      "MYSQL_PASSWORD",
+			ValueFrom: passwordSecret,
+		},
+	}
+}
+
+// Returns a hash of the keys of the credentials Secret the backend reads, or
+// an empty string when the Secret does not exist (yet)
+func (r *VisitorsAppReconciler) mysqlCredentialsHash(ctx context.Context, v *examplecomv1.VisitorsApp) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      mysqlAuthName(v),
+		Namespace: v.Namespace,
+	}, secret)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, key := range []string{mysqlUserKey(v), mysqlPasswordKey(v)} {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(secret.Data[key])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Renders the backend Deployment with the hash of the current credentials on
+// its pod template
+func (r *VisitorsAppReconciler) backendDeploymentWithCredentials(ctx context.Context, v *examplecomv1.VisitorsApp) (*appsv1.Deployment, error) {
+	dep := r.backendDeployment(v)
+
+	hash, err := r.mysqlCredentialsHash(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		dep.Spec.Template.Annotations = map[string]string{credentialsHashAnnotation: hash}
+	}
+	return dep, nil
+}
+
+func (r *VisitorsAppReconciler) backendDeployment(v *examplecomv1.VisitorsApp) *appsv1.Deployment {
+	labels := labels(v, "backend")
+	backendSize := backendSize(v)
+
+	// With auto-scaling enabled the replicas are left to the
+	// HorizontalPodAutoscaler, once ensureDeployment handed them over
+	var replicas *int32
+	if !backendAutoScaling(v) {
+		replicas = &backendSize
+	}
+
 	dep := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      backendDeploymentName(v),
 			Namespace: v.Namespace,
+			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &backendSize,
+			Replicas: replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -62,40 +175,18 @@ func (r *VisitorsAppReconciler) backendDeployment(v *examplecomv1beta1.VisitorsA
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image:           backendImage,
-						ImagePullPolicy: corev1.PullAlways,
+						Image:           backendImage(v),
+						ImagePullPolicy: backendImagePullPolicy(v),
 						Name:            "visitors-service",
 						Ports: []corev1.ContainerPort{{
 							ContainerPort: backendPort,
 							Name:          "visitors",
 						}},
-						Env: []corev1.EnvVar{
-							{
-								Name:  "MYSQL_DATABASE",
-								Value: "visitors_db",
-							},
-							{
-								Name:  "MYSQL_SERVICE_HOST_RW",
-								Value: mysqlServiceRWName(),
-							},
-							{
-								Name:  "MYSQL_SERVICE_HOST_RO",
-								Value: mysqlServiceROName(),
-							},
-							{
-								Name:      "MYSQL_USERNAME",
-								ValueFrom: userSecret,
-							},
-							{
-								Name:      "MYSQL_PASSWORD",
-								ValueFrom: passwordSecret,
-							},
-						},
-						Resources: corev1.ResourceRequirements{
-							Requests: corev1.ResourceList{
-								"cpu": resource.MustParse("200m"),
-							},
-						},
+						Env:            backendEnv(v),
+						Resources:      backendResources(v),
+						StartupProbe:   probe(v.Spec.Backend.StartupProbe, startupProbe(tcpProbeHandler(backendPort))),
+						LivenessProbe:  probe(v.Spec.Backend.LivenessProbe, livenessProbe(tcpProbeHandler(backendPort))),
+						ReadinessProbe: probe(v.Spec.Backend.ReadinessProbe, readinessProbe(httpProbeHandler(backendReadinessPath, backendPort))),
 					}},
 				},
 			},
@@ -106,90 +197,96 @@ func (r *VisitorsAppReconciler) backendDeployment(v *examplecomv1beta1.VisitorsA
 	return dep
 }
 
-func (r *VisitorsAppReconciler) backendService(v *examplecomv1beta1.VisitorsApp) *corev1.Service {
+// The backend Service selects the pods of the stable and Canary Deployments,
+// or only the BlueGreen preview pods while they are being promoted
+func (r *VisitorsAppReconciler) backendService(v *examplecomv1.VisitorsApp) *corev1.Service {
 	labels := labels(v, "backend")
-	backendServiceNodePort := v.Spec.BackendServiceNodePort
+	selector := labels
+	if rolloutInProgress(v) && v.Status.BackendRollout.Strategy == examplecomv1.RolloutStrategyBlueGreen &&
+		v.Status.BackendRollout.Phase == examplecomv1.RolloutPhasePromoting {
+		selector = backendCandidateLabels(v, examplecomv1.RolloutStrategyBlueGreen)
+	}
 
 	s := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      backendServiceName(v),
 			Namespace: v.Namespace,
+			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
+			Selector: selector,
 			Ports: []corev1.ServicePort{{
 				Protocol:   corev1.ProtocolTCP,
 				Port:       backendPort,
 				TargetPort: intstr.FromInt(int(backendPort)),
-				NodePort:   backendServiceNodePort,
 			}},
-			Type: corev1.ServiceTypeNodePort,
 		},
 	}
+	exposeService(s, v.Spec.Backend.Service)
 
 	controllerutil.SetControllerReference(v, s, r.Scheme)
 	return s
 }
 
-func (r *VisitorsAppReconciler) updateBackendStatus(ctx context.Context, v *examplecomv1beta1.VisitorsApp) error {
-	v.Status.BackendImage = backendImage
-	err := r.Update(ctx, v)
-	return err
-}
-
-func (r *VisitorsAppReconciler) handleBackendChanges(ctx context.Context, v *examplecomv1beta1.VisitorsApp) (*ctrl.Result, error) {
-	log := ctrllog.FromContext(ctx)
-
-	foundDeployment := &appsv1.Deployment{}
-	foundService := &corev1.Service{}
-
+// Fills the backend part of the status from what the Deployment and Service
+// are actually running with. Objects that do not exist yet are reported as such.
+// The pods of a rollout in progress are counted along with the stable ones.
+// Returns whether the Deployment finished rolling out, and no other rollout is
+// in progress.
+func (r *VisitorsAppReconciler) updateBackendStatus(ctx context.Context, v *examplecomv1.VisitorsApp) (bool, error) {
+	rolledOut := false
+	dep := &appsv1.Deployment{}
 	err := r.Get(ctx, types.NamespacedName{
 		Name:      backendDeploymentName(v),
 		Namespace: v.Namespace,
-	}, foundDeployment)
-	if err != nil {
-		// The deployment may not have been created yet, so requeue
-		return &ctrl.Result{RequeueAfter: 5 * time.Second}, err
+	}, dep)
+	if err != nil && errors.IsNotFound(err) {
+		v.Status.Backend.Replicas = 0
+		v.Status.Backend.ReadyReplicas = 0
+		v.Status.Backend.UpdatedReplicas = 0
+		setCondition(v, examplecomv1.ConditionBackendAvailable, metav1.ConditionFalse, "DeploymentNotFound",
+			fmt.Sprintf("Deployment %s has not been created yet", backendDeploymentName(v)))
+	} else if err != nil {
+		return false, err
+	} else {
+		v.Status.Backend.Image = dep.Spec.Template.Spec.Containers[0].Image
+		v.Status.Backend.Replicas = *dep.Spec.Replicas
+		v.Status.Backend.ReadyReplicas = dep.Status.ReadyReplicas
+		v.Status.Backend.UpdatedReplicas = dep.Status.UpdatedReplicas
+		rolledOut = deploymentRolledOut(dep) && !rolloutInProgress(v)
+		setDeploymentCondition(v, examplecomv1.ConditionBackendAvailable, dep)
 	}
-	err = r.Get(ctx, types.NamespacedName{
-		Name:      backendServiceName(v),
-		Namespace: v.Namespace,
-	}, foundService)
-	if err != nil {
-		// The service may not have been created yet, so requeue
-		return &ctrl.Result{RequeueAfter: 5 * time.Second}, err
-	}
-
-	backendAutoScaling := v.Spec.BackendAutoScaling
-	backendSize := v.Spec.BackendSize
-	backendServiceNodePort := v.Spec.BackendServiceNodePort
-
-	existingBackendSize := *foundDeployment.Spec.Replicas
-	existingBackendServiceNodePort := (*foundService).Spec.Ports[0].NodePort
-
-	if !backendAutoScaling {
-		if backendSize != existingBackendSize {
-			foundDeployment.Spec.Replicas = &backendSize
-			err = r.Update(ctx, foundDeployment)
-			if err != nil {
-				log.Error(err, "Failed to update Deployment.", "Deployment.Namespace", foundDeployment.Namespace, "Deployment.Name", foundDeployment.Name)
-				return &ctrl.Result{}, err
-			}
-			// Spec updated - return and requeue
-			return &ctrl.Result{Requeue: true}, nil
+
+	for _, name := range []string{backendCanaryDeploymentName(v), backendPreviewDeploymentName(v)} {
+		candidate := &appsv1.Deployment{}
+		err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: v.Namespace}, candidate)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
 		}
+		if candidate.Spec.Replicas != nil {
+			v.Status.Backend.Replicas += *candidate.Spec.Replicas
+		}
+		v.Status.Backend.ReadyReplicas += candidate.Status.ReadyReplicas
 	}
 
-	if backendServiceNodePort != existingBackendServiceNodePort {
-		(*foundService).Spec.Ports[0].NodePort = backendServiceNodePort
-		err = r.Update(ctx, foundService)
-		if err != nil {
-			log.Error(err, "Failed to update Service.", "Service.Namespace", foundService.Namespace, "Service.Name", foundService.Name)
-			return &ctrl.Result{}, err
-		}
-		// Spec updated - return and requeue
-		return &ctrl.Result{Requeue: true}, nil
+	s := &corev1.Service{}
+	err = r.Get(ctx, types.NamespacedName{
+		Name:      backendServiceName(v),
+		Namespace: v.Namespace,
+	}, s)
+	if err != nil && errors.IsNotFound(err) {
+		v.Status.Backend.URL = ""
+	} else if err != nil {
+		return false, err
+	} else {
+		v.Status.Backend.URL = serviceURL(s)
 	}
 
-	return nil, nil
+	return rolledOut, nil
 }
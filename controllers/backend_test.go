@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Tier images", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		r        *VisitorsAppReconciler
+		recorder *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "images", Namespace: "default", UID: "images-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{
+					Image: examplecomv1.ImageSpec{Repository: "registry.example.com/visitors-service", Tag: "1.0.0"},
+				}},
+				Frontend: examplecomv1.FrontendSpec{TierSpec: examplecomv1.TierSpec{
+					Image: examplecomv1.ImageSpec{
+						Repository: "registry.example.com/visitors-webui",
+						Digest:     "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+						PullPolicy: corev1.PullIfNotPresent,
+					},
+				}},
+			},
+		}
+		r = newTestReconciler(app)
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady"}
+		recorder = r.Recorder.(*record.FakeRecorder)
+	})
+
+	container := func(name string) corev1.Container {
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, dep)).To(Succeed())
+		return dep.Spec.Template.Spec.Containers[0]
+	}
+
+	It("runs the images of the spec", func() {
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		v, _ := reconcileApp(ctx, r, app)
+
+		backend := container(backendDeploymentName(app))
+		Expect(backend.Image).To(Equal("registry.example.com/visitors-service:1.0.0"))
+		Expect(backend.ImagePullPolicy).To(Equal(corev1.PullAlways))
+		frontend := container(frontendDeploymentName(app))
+		Expect(frontend.Image).To(Equal("registry.example.com/visitors-webui@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"))
+		Expect(frontend.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+
+		Expect(v.Status.Backend.Image).To(Equal(backend.Image))
+		Expect(v.Status.Frontend.Image).To(Equal(frontend.Image))
+	})
+
+	It("rolls the Deployment to a new image", func() {
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		v, _ := reconcileApp(ctx, r, app)
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+
+		v.Spec.Backend.Image.Tag = "1.1.0"
+		Expect(r.Update(ctx, v)).To(Succeed())
+		v, _ = reconcileApp(ctx, r, app)
+
+		Expect(container(backendDeploymentName(app)).Image).To(Equal("registry.example.com/visitors-service:1.1.0"))
+		Expect(v.Status.Backend.Image).To(Equal("registry.example.com/visitors-service:1.1.0"))
+		Expect(recorder.Events).To(Receive(Equal("Normal Updated Updated Deployment images-backend to match the VisitorsApp")))
+		Expect(recorder.Events).To(Receive(Equal("Normal ImageChanged Changed the image of Deployment images-backend " +
+			"from registry.example.com/visitors-service:1.0.0 to registry.example.com/visitors-service:1.1.0")))
+	})
+})
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Directory of the Job containers the dump is written to or read from
+const backupDir = "/backup"
+
+const defaultS3Image = "minio/mc"
+
+// How long a backup or restore Job may run unless the backup sets its own
+// deadline. A Job whose pods cannot start, such as for a missing Secret key,
+// fails once it passed.
+const defaultBackupDeadlineSeconds = 3600
+
+// Scripts of the backup and restore Jobs. The dump is written to a temporary
+// file first, so that a failed dump never replaces a good one. It does not
+// name its database, so it can be loaded into the database of another
+// VisitorsApp.
+const (
+	dumpScript = `set -e
+mkdir -p "$(dirname "$BACKUP_FILE")"
+mysqldump -h "$MYSQL_HOST" -u root --single-transaction --routines --triggers "$DATABASE_NAME" > "$BACKUP_FILE.sql"
+gzip -c "$BACKUP_FILE.sql" > "$BACKUP_FILE.tmp"
+rm "$BACKUP_FILE.sql"
+mv "$BACKUP_FILE.tmp" "$BACKUP_FILE"
+`
+	loadScript = `set -e
+gunzip -t "$BACKUP_FILE"
+gunzip -c "$BACKUP_FILE" | mysql -h "$MYSQL_HOST" -u root -D "$DATABASE_NAME"
+`
+	uploadScript = `set -e
+mc alias set target "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"
+mc cp "$BACKUP_FILE" "target/$S3_OBJECT"
+`
+	downloadScript = `set -e
+mc alias set target "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"
+mc cp "target/$S3_OBJECT" "$BACKUP_FILE"
+`
+	removeScript = `set -e
+rm -f "$BACKUP_FILE"
+`
+	removeObjectScript = `set -e
+mc alias set target "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY"
+mc rm --force "target/$S3_OBJECT"
+`
+)
+
+func backupJobName(b *examplecomv1.VisitorsAppBackup) string {
+	return b.Name + "-backup"
+}
+
+func restoreJobName(r *examplecomv1.VisitorsAppRestore) string {
+	return r.Name + "-restore"
+}
+
+func removeDumpJobName(b *examplecomv1.VisitorsAppBackup) string {
+	return b.Name + "-remove"
+}
+
+// Returns an error when the target of a backup is not usable
+func validateBackupTarget(target examplecomv1.BackupTarget) error {
+	if (target.PersistentVolumeClaim == nil) == (target.S3 == nil) {
+		return fmt.Errorf("exactly one of persistentVolumeClaim and s3 has to be set in the target")
+	}
+	return nil
+}
+
+// Returns the name of the dump of a backup, relative to its target
+func backupObject(b *examplecomv1.VisitorsAppBackup) string {
+	file := b.Name + ".sql.gz"
+	if pvc := b.Spec.Target.PersistentVolumeClaim; pvc != nil {
+		return path.Join(strings.TrimPrefix(pvc.Path, "/"), file)
+	}
+	return path.Join(b.Spec.Target.S3.Prefix, file)
+}
+
+// Returns the location of the dump of a backup, as reported in its status
+func backupLocation(b *examplecomv1.VisitorsAppBackup) string {
+	if pvc := b.Spec.Target.PersistentVolumeClaim; pvc != nil {
+		return "pvc://" + path.Join(pvc.ClaimName, backupObject(b))
+	}
+	return "s3://" + path.Join(b.Spec.Target.S3.Bucket, backupObject(b))
+}
+
+// Returns the volume holding the dump of b in the Job containers, and the
+// path of the dump on it. With an S3 target, it is a scratch volume the dump
+// is copied through.
+func backupVolume(b *examplecomv1.VisitorsAppBackup) (corev1.Volume, string) {
+	if pvc := b.Spec.Target.PersistentVolumeClaim; pvc != nil {
+		return corev1.Volume{
+			Name: "backup",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.ClaimName},
+			},
+		}, path.Join(backupDir, backupObject(b))
+	}
+	return corev1.Volume{
+		Name:         "backup",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}, path.Join(backupDir, path.Base(backupObject(b)))
+}
+
+// Renders a Job dumping the database of v to the target of b, or loading it
+// from there when restore is set. The mysql container runs with the root
+// credentials of v. With an S3 target, an mc container copies the dump
+// between the bucket and a scratch volume; it runs after the dump, or before
+// the load as an init container.
+func backupJob(b *examplecomv1.VisitorsAppBackup, v *examplecomv1.VisitorsApp, name string, namespace string, image string, restore bool) *batchv1.Job {
+	script := dumpScript
+	if restore {
+		script = loadScript
+	}
+	if image == "" {
+		image = "mysql:" + mysqlVersion(v)
+	}
+
+	volume, file := backupVolume(b)
+	mounts := []corev1.VolumeMount{{Name: "backup", MountPath: backupDir}}
+
+	mysql := corev1.Container{
+		Name:    "mysql",
+		Image:   image,
+		Command: []string{"sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{Name: "MYSQL_HOST", Value: mysqlServiceHost(v, mysqlServiceRWName(v))},
+			{Name: "MYSQL_PWD", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName(v)},
+					Key:                  mysqlRootPasswordKey(v),
+				},
+			}},
+			{Name: "DATABASE_NAME", Value: mysqlDatabaseName(v)},
+			{Name: "BACKUP_FILE", Value: file},
+		},
+		VolumeMounts: mounts,
+	}
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Containers:    []corev1.Container{mysql},
+		Volumes:       []corev1.Volume{volume},
+	}
+
+	if s3 := b.Spec.Target.S3; s3 != nil {
+		copyScript := uploadScript
+		if restore {
+			copyScript = downloadScript
+		}
+		transfer := s3Container(b, copyScript, file)
+
+		// The dump has to be complete before it is uploaded, and downloaded
+		// before it is loaded, so the first step runs as an init container
+		if restore {
+			podSpec.InitContainers = []corev1.Container{transfer}
+		} else {
+			podSpec.InitContainers = []corev1.Container{mysql}
+			podSpec.Containers = []corev1.Container{transfer}
+		}
+	}
+
+	return newBackupJob(b, name, namespace, labels(v, "backup"), podSpec)
+}
+
+// Renders the mc container running script against the S3 target of b
+func s3Container(b *examplecomv1.VisitorsAppBackup, script string, file string) corev1.Container {
+	s3 := b.Spec.Target.S3
+	s3Image := s3.Image
+	if s3Image == "" {
+		s3Image = defaultS3Image
+	}
+	secretKey := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: s3.CredentialsSecret},
+				Key:                  key,
+			},
+		}
+	}
+
+	return corev1.Container{
+		Name:    "s3",
+		Image:   s3Image,
+		Command: []string{"sh", "-c", script},
+		Env: []corev1.EnvVar{
+			{Name: "S3_ENDPOINT", Value: s3.Endpoint},
+			{Name: "S3_OBJECT", Value: path.Join(s3.Bucket, backupObject(b))},
+			{Name: "AWS_ACCESS_KEY_ID", ValueFrom: secretKey("AWS_ACCESS_KEY_ID")},
+			{Name: "AWS_SECRET_ACCESS_KEY", ValueFrom: secretKey("AWS_SECRET_ACCESS_KEY")},
+			{Name: "BACKUP_FILE", Value: file},
+			// mc keeps its configuration in the home directory
+			{Name: "HOME", Value: "/tmp"},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "backup", MountPath: backupDir}},
+	}
+}
+
+// Renders a Job removing the dump of b from its target. It does not need the
+// VisitorsApp, which may be gone by the time its backups are deleted.
+func removeDumpJob(b *examplecomv1.VisitorsAppBackup) *batchv1.Job {
+	volume, file := backupVolume(b)
+
+	var container corev1.Container
+	if b.Spec.Target.S3 != nil {
+		container = s3Container(b, removeObjectScript, file)
+	} else {
+		image := b.Spec.Image
+		if image == "" {
+			version := b.Status.MysqlVersion
+			if version == "" {
+				version = mysqlDefaultVersion
+			}
+			image = "mysql:" + version
+		}
+		container = corev1.Container{
+			Name:         "remove",
+			Image:        image,
+			Command:      []string{"sh", "-c", removeScript},
+			Env:          []corev1.EnvVar{{Name: "BACKUP_FILE", Value: file}},
+			VolumeMounts: []corev1.VolumeMount{{Name: "backup", MountPath: backupDir}},
+		}
+	}
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Containers:    []corev1.Container{container},
+		Volumes:       []corev1.Volume{volume},
+	}
+	v := &examplecomv1.VisitorsApp{ObjectMeta: metav1.ObjectMeta{Name: b.Spec.VisitorsAppName}}
+	return newBackupJob(b, removeDumpJobName(b), b.Namespace, labels(v, "backup"), podSpec)
+}
+
+// Renders a Job of b, which fails once it ran past the deadline of b
+func newBackupJob(b *examplecomv1.VisitorsAppBackup, name string, namespace string, labels map[string]string, podSpec corev1.PodSpec) *batchv1.Job {
+	backoffLimit := int32(2)
+	deadline := int64(defaultBackupDeadlineSeconds)
+	if b.Spec.ActiveDeadlineSeconds != nil {
+		deadline = *b.Spec.ActiveDeadlineSeconds
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
+
+// Returns whether the Job finished, and the reason of its failure if it failed
+func jobFinished(job *batchv1.Job) (bool, error) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return true, fmt.Errorf("job %s failed: %s", job.Name, c.Message)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("VisitorsApp backups", func() {
+	var (
+		ctx         context.Context
+		c           client.Client
+		app         *examplecomv1.VisitorsApp
+		credentials *corev1.Secret
+		backup      *examplecomv1.VisitorsAppBackup
+		backups     *VisitorsAppBackupReconciler
+		restores    *VisitorsAppRestoreReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Frontend: examplecomv1.FrontendSpec{Title: "Backed up"},
+			},
+		}
+		credentials = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: "default"},
+			Data:       map[string][]byte{"ROOT_PASSWORD": []byte("root-password")},
+		}
+		backup = &examplecomv1.VisitorsAppBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "daily", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppBackupSpec{
+				VisitorsAppName: "visitors",
+				Target: examplecomv1.BackupTarget{
+					PersistentVolumeClaim: &examplecomv1.PersistentVolumeClaimTarget{ClaimName: "backups", Path: "visitors"},
+				},
+			},
+		}
+
+		r := newTestReconciler(app, credentials, backup)
+		c = r.Client
+		backups = &VisitorsAppBackupReconciler{Client: c, Scheme: r.Scheme, Recorder: record.NewFakeRecorder(10)}
+		restores = &VisitorsAppRestoreReconciler{Client: c, Scheme: r.Scheme, Recorder: record.NewFakeRecorder(10)}
+	})
+
+	reconcileBackup := func() *examplecomv1.VisitorsAppBackup {
+		_, err := backups.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(backup)})
+		Expect(err).NotTo(HaveOccurred())
+		b := &examplecomv1.VisitorsAppBackup{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(backup), b)).To(Succeed())
+		return b
+	}
+
+	setDatabaseReady := func() {
+		v := &examplecomv1.VisitorsApp{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(app), v)).To(Succeed())
+		setDatabaseCondition(v, DatabaseStatus{Ready: true, Reason: "MysqlReady"})
+		Expect(c.Status().Update(ctx, v)).To(Succeed())
+	}
+
+	completeJob := func(name string) {
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	It("dumps the database to a volume once it is ready", func() {
+		Expect(reconcileBackup().Status.Phase).To(Equal(examplecomv1.BackupPhasePending))
+
+		setDatabaseReady()
+		b := reconcileBackup()
+		Expect(b.Status.Phase).To(Equal(examplecomv1.BackupPhaseRunning))
+		Expect(b.Status.Location).To(Equal("pvc://backups/visitors/daily.sql.gz"))
+		Expect(b.Status.VisitorsAppSpec.Frontend.Title).To(Equal("Backed up"))
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "daily-backup", Namespace: "default"}, job)).To(Succeed())
+		pod := job.Spec.Template.Spec
+		Expect(pod.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("backups"))
+		Expect(pod.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "BACKUP_FILE", Value: "/backup/visitors/daily.sql.gz"}))
+		Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(int64(defaultBackupDeadlineSeconds))))
+
+		completeJob("daily-backup")
+		b = reconcileBackup()
+		Expect(b.Status.Phase).To(Equal(examplecomv1.BackupPhaseCompleted))
+		Expect(b.Status.CompletionTime).NotTo(BeNil())
+	})
+
+	It("uploads the dump of an S3 target after it is complete", func() {
+		backup.Spec.Target = examplecomv1.BackupTarget{
+			S3: &examplecomv1.S3Target{
+				Endpoint:          "http://minio.minio:9000",
+				Bucket:            "backups",
+				Prefix:            "visitors",
+				CredentialsSecret: "minio-credentials",
+			},
+		}
+
+		job := backupJob(backup, app, "daily-backup", "default", "", false)
+		pod := job.Spec.Template.Spec
+		Expect(pod.InitContainers[0].Name).To(Equal("mysql"))
+		Expect(pod.Containers[0].Image).To(Equal(defaultS3Image))
+		Expect(pod.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "S3_OBJECT", Value: "backups/visitors/daily.sql.gz"}))
+		Expect(pod.Volumes[0].EmptyDir).NotTo(BeNil())
+		Expect(backupLocation(backup)).To(Equal("s3://backups/visitors/daily.sql.gz"))
+
+		job = backupJob(backup, app, "daily-restore", "default", "", true)
+		Expect(job.Spec.Template.Spec.InitContainers[0].Name).To(Equal("s3"))
+	})
+
+	It("fails a backup when the credentials lack the root password", func() {
+		delete(credentials.Data, "ROOT_PASSWORD")
+		Expect(c.Update(ctx, credentials)).To(Succeed())
+		setDatabaseReady()
+
+		b := reconcileBackup()
+		Expect(b.Status.Phase).To(Equal(examplecomv1.BackupPhaseFailed))
+		Expect(b.Status.Message).To(Equal("Secret my-secret has no key ROOT_PASSWORD"))
+		job := &batchv1.Job{}
+		Expect(apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Name: "daily-backup", Namespace: "default"}, job))).To(BeTrue())
+	})
+
+	It("fails a backup whose Job ran past its deadline", func() {
+		deadline := int64(600)
+		backup.Spec.ActiveDeadlineSeconds = &deadline
+		Expect(c.Update(ctx, backup)).To(Succeed())
+		setDatabaseReady()
+		reconcileBackup()
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "daily-backup", Namespace: "default"}, job)).To(Succeed())
+		Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(deadline)))
+
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type:    batchv1.JobFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  "DeadlineExceeded",
+			Message: "Job was active longer than specified deadline",
+		}}
+		Expect(c.Status().Update(ctx, job)).To(Succeed())
+		b := reconcileBackup()
+		Expect(b.Status.Phase).To(Equal(examplecomv1.BackupPhaseFailed))
+		Expect(b.Status.Message).To(ContainSubstring("longer than specified deadline"))
+	})
+
+	It("fails a backup without a target", func() {
+		backup.Spec.Target = examplecomv1.BackupTarget{}
+		Expect(c.Update(ctx, backup)).To(Succeed())
+		Expect(reconcileBackup().Status.Phase).To(Equal(examplecomv1.BackupPhaseFailed))
+	})
+
+	It("removes the dump before deleting a backup with the Delete policy", func() {
+		backup.Spec.DeletionPolicy = examplecomv1.BackupDeletionPolicyDelete
+		Expect(c.Update(ctx, backup)).To(Succeed())
+		setDatabaseReady()
+		reconcileBackup()
+		completeJob("daily-backup")
+		Expect(reconcileBackup().Finalizers).To(ContainElement(visitorsAppFinalizer))
+
+		Expect(c.Delete(ctx, backup)).To(Succeed())
+		b := reconcileBackup()
+		Expect(b.DeletionTimestamp).NotTo(BeNil())
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "daily-remove", Namespace: "default"}, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "BACKUP_FILE", Value: "/backup/visitors/daily.sql.gz"}))
+
+		completeJob("daily-remove")
+		_, err := backups.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(backup)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(backup), b))).To(BeTrue())
+	})
+
+	It("recreates a deleted VisitorsApp before restoring its database", func() {
+		setDatabaseReady()
+		reconcileBackup()
+		completeJob("daily-backup")
+		reconcileBackup()
+		Expect(c.Delete(ctx, app)).To(Succeed())
+
+		restore := &examplecomv1.VisitorsAppRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: "rollback", Namespace: "default"},
+			Spec:       examplecomv1.VisitorsAppRestoreSpec{BackupName: "daily"},
+		}
+		Expect(c.Create(ctx, restore)).To(Succeed())
+		reconcileRestore := func() *examplecomv1.VisitorsAppRestore {
+			_, err := restores.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+			Expect(err).NotTo(HaveOccurred())
+			r := &examplecomv1.VisitorsAppRestore{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(restore), r)).To(Succeed())
+			return r
+		}
+
+		Expect(reconcileRestore().Status.Phase).To(Equal(examplecomv1.BackupPhasePending))
+		v := &examplecomv1.VisitorsApp{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(app), v)).To(Succeed())
+		Expect(v.Spec.Frontend.Title).To(Equal("Backed up"))
+
+		setDatabaseReady()
+		Expect(reconcileRestore().Status.Phase).To(Equal(examplecomv1.BackupPhaseRunning))
+
+		completeJob("rollback-restore")
+		Expect(reconcileRestore().Status.Phase).To(Equal(examplecomv1.BackupPhaseCompleted))
+	})
+
+	It("loads a backup into the database of the VisitorsApp it is restored into", func() {
+		setDatabaseReady()
+		reconcileBackup()
+		completeJob("daily-backup")
+		reconcileBackup()
+
+		staging := &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{DatabaseName: "staging_db"},
+			},
+		}
+		Expect(c.Create(ctx, staging)).To(Succeed())
+		setDatabaseCondition(staging, DatabaseStatus{Ready: true, Reason: "MysqlReady"})
+		Expect(c.Status().Update(ctx, staging)).To(Succeed())
+
+		restore := &examplecomv1.VisitorsAppRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "default"},
+			Spec:       examplecomv1.VisitorsAppRestoreSpec{BackupName: "daily", VisitorsAppName: "staging"},
+		}
+		Expect(c.Create(ctx, restore)).To(Succeed())
+		_, err := restores.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+		Expect(err).NotTo(HaveOccurred())
+
+		dump := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "daily-backup", Namespace: "default"}, dump)).To(Succeed())
+		Expect(dump.Spec.Template.Spec.Containers[0].Command[2]).NotTo(ContainSubstring("--databases"))
+
+		job := &batchv1.Job{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "staging-restore", Namespace: "default"}, job)).To(Succeed())
+		mysql := job.Spec.Template.Spec.Containers[0]
+		Expect(mysql.Env).To(ContainElement(corev1.EnvVar{Name: "DATABASE_NAME", Value: "staging_db"}))
+		Expect(mysql.Command[2]).To(ContainSubstring(`mysql -h "$MYSQL_HOST" -u root -D "$DATABASE_NAME"`))
+	})
+})
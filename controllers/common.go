@@ -2,92 +2,288 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 
-	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// Field manager the operator applies its objects with
+const fieldManager = "visitors-operator"
+
 func (r *VisitorsAppReconciler) ensureDeployment(ctx context.Context,
 	request ctrl.Request,
-	instance *examplecomv1beta1.VisitorsApp,
+	instance *examplecomv1.VisitorsApp,
 	dep *appsv1.Deployment,
 ) (*ctrl.Result, error) {
 	log := ctrllog.FromContext(ctx)
 
-	// See if deployment already exists and create if it doesn't
-	found := &appsv1.Deployment{}
-	err := r.Get(ctx, types.NamespacedName{
-		Name:      dep.Name,
-		Namespace: instance.Namespace,
-	}, found)
-	if err != nil && errors.IsNotFound(err) {
-
-		// Create the deployment
-		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
-		err = r.Create(ctx, dep)
-
-		if err != nil {
-			// Deployment failed
-			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+	// Replicas left out of the apply are reset to their default as long as
+	// the operator owns them, so the current ones are applied until the
+	// HorizontalPodAutoscaler took them over
+	if dep.Spec.Replicas == nil {
+		current := &appsv1.Deployment{}
+		err := r.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, current)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get Deployment")
 			return &ctrl.Result{}, err
-		} else {
-			// Deployment was successful
-			return nil, nil
 		}
-	} else if err != nil {
-		// Error that isn't due to the deployment not existing
-		log.Error(err, "Failed to get Deployment")
-		return &ctrl.Result{}, err
+		if err == nil && !replicasManagedElsewhere(current) {
+			dep.Spec.Replicas = current.Spec.Replicas
+		}
 	}
 
+	found := &appsv1.Deployment{}
+	result, err := r.ensureApplied(ctx, instance, dep, found)
+	if result != nil {
+		return result, err
+	}
+
+	if found.ResourceVersion != "" {
+		r.recordDeploymentChanges(instance, found, dep)
+	}
 	return nil, nil
 }
 
+// Returns whether a field manager other than the operator owns the replicas
+// of a Deployment
+func replicasManagedElsewhere(dep *appsv1.Deployment) bool {
+	for _, entry := range dep.ManagedFields {
+		if entry.Manager == fieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		fields := struct {
+			Spec map[string]json.RawMessage `json:"f:spec"`
+		}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields.Spec["f:replicas"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *VisitorsAppReconciler) ensureService(ctx context.Context,
 	request ctrl.Request,
-	instance *examplecomv1beta1.VisitorsApp,
+	instance *examplecomv1.VisitorsApp,
 	s *corev1.Service,
 ) (*ctrl.Result, error) {
 	log := ctrllog.FromContext(ctx)
 
+	// A Service can only become a ClusterIP Service once its node ports and
+	// the fields depending on them are cleared, including the ones that were
+	// allocated by the API server rather than applied by the operator
 	found := &corev1.Service{}
 	err := r.Get(ctx, types.NamespacedName{
 		Name:      s.Name,
-		Namespace: instance.Namespace,
+		Namespace: s.Namespace,
 	}, found)
-	if err != nil && errors.IsNotFound(err) {
-
-		// Create the service
-		log.Info("Creating a new Service", "Service.Namespace", s.Namespace, "Service.Name", s.Name)
-		err = r.Create(ctx, s)
-
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Service")
+		return &ctrl.Result{}, err
+	}
+	if err == nil && found.Spec.Type != s.Spec.Type && s.Spec.Type == corev1.ServiceTypeClusterIP {
+		log.Info("Changing Service type", "Service.Namespace", found.Namespace, "Service.Name", found.Name,
+			"From", found.Spec.Type, "To", s.Spec.Type)
+		found.Spec.Type = corev1.ServiceTypeClusterIP
+		for i := range found.Spec.Ports {
+			found.Spec.Ports[i].NodePort = 0
+		}
+		found.Spec.ExternalTrafficPolicy = ""
+		found.Spec.HealthCheckNodePort = 0
+		found.Spec.LoadBalancerSourceRanges = nil
+		err = r.Update(ctx, found, client.FieldOwner(fieldManager))
 		if err != nil {
-			// Creation failed
-			log.Error(err, "Failed to create new Service", "Service.Namespace", s.Namespace, "Service.Name", s.Name)
+			log.Error(err, "Failed to update Service.", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
 			return &ctrl.Result{}, err
-		} else {
-			// Creation was successful
-			return nil, nil
 		}
+	}
+
+	return r.ensureApplied(ctx, instance, s, &corev1.Service{})
+}
+
+// Creates or updates obj with server-side apply. Every field rendered by the
+// operator is enforced on each reconcile, while fields it does not render
+// (e.g. replicas handed over to an autoscaler) are left to their other managers.
+// found must be an empty object of the same kind as obj, it is left with the
+// object as it was before the apply.
+func (r *VisitorsAppReconciler) ensureApplied(ctx context.Context, instance *examplecomv1.VisitorsApp, obj client.Object, found client.Object) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		// Error that isn't due to the object not existing
+		log.Error(err, "Failed to get "+kind)
+		return &ctrl.Result{}, err
+	}
+	exists := err == nil
+
+	if !exists {
+		log.Info("Creating a new "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+	}
+
+	err = r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	if err != nil {
+		log.Error(err, "Failed to apply "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+		return &ctrl.Result{}, err
+	}
+
+	if !exists {
+		r.Recorder.Event(instance, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe(kind, obj))
+	} else if obj.GetResourceVersion() != found.GetResourceVersion() {
+		log.Info("Updated "+kind+" to match the VisitorsApp", kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+		r.Recorder.Event(instance, corev1.EventTypeNormal, eventReasonUpdated, "Updated "+describe(kind, obj)+" to match the VisitorsApp")
+		observeDriftCorrections(instance, kind, found, obj)
+	}
+	recordApplied(instance, kind, obj)
+
+	return nil, nil
+}
+
+// Sets the type and the type dependent fields of a rendered Service
+func exposeService(s *corev1.Service, spec examplecomv1.ServiceSpec) {
+	s.Spec.Type = spec.Type
+	if s.Spec.Type == "" {
+		s.Spec.Type = corev1.ServiceTypeNodePort
+	}
+	s.Annotations = spec.Annotations
+
+	if s.Spec.Type == corev1.ServiceTypeClusterIP {
+		return
+	}
+
+	for i := range s.Spec.Ports {
+		s.Spec.Ports[i].NodePort = spec.NodePort
+	}
+	s.Spec.ExternalTrafficPolicy = spec.ExternalTrafficPolicy
+	if s.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		s.Spec.LoadBalancerSourceRanges = spec.LoadBalancerSourceRanges
+	}
+}
+
+// Deletes obj if it exists and is controlled by the VisitorsApp, so objects
+// created by hand under the same name are never removed
+func (r *VisitorsAppReconciler) ensureDeleted(ctx context.Context, instance *examplecomv1.VisitorsApp, obj client.Object) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}, obj)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, nil
 	} else if err != nil {
-		// Error that isn't due to the service not existing
-		log.Error(err, "Failed to get Service")
+		log.Error(err, "Failed to get "+kind)
+		return &ctrl.Result{}, err
+	}
+
+	if !metav1.IsControlledBy(obj, instance) {
+		return nil, nil
+	}
+
+	log.Info("Deleting "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+	err = r.Delete(ctx, obj)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+		return &ctrl.Result{}, err
+	}
+	r.Recorder.Event(instance, corev1.EventTypeNormal, eventReasonDeleted, "Deleted "+describe(kind, obj))
+
+	return nil, nil
+}
+
+// Removes the controller reference to the VisitorsApp from obj, so that it
+// is not garbage collected with the VisitorsApp
+func (r *VisitorsAppReconciler) ensureOrphaned(ctx context.Context, instance *examplecomv1.VisitorsApp, obj client.Object) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}, obj)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get "+kind)
+		return &ctrl.Result{}, err
+	}
+
+	if !metav1.IsControlledBy(obj, instance) {
+		return nil, nil
+	}
+
+	refs := []metav1.OwnerReference{}
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != instance.UID {
+			refs = append(refs, ref)
+		}
+	}
+	obj.SetOwnerReferences(refs)
+
+	log.Info("Orphaning "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+	err = r.Update(ctx, obj)
+	if err != nil {
+		log.Error(err, "Failed to orphan "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
 		return &ctrl.Result{}, err
 	}
+	r.Recorder.Event(instance, corev1.EventTypeNormal, eventReasonOrphaned, "Kept "+describe(kind, obj)+" for the deletion policy")
 
 	return nil, nil
 }
 
-func labels(v *examplecomv1beta1.VisitorsApp, tier string) map[string]string {
+func labels(v *examplecomv1.VisitorsApp, tier string) map[string]string {
 	return map[string]string{
 		"app":             "visitors",
 		"visitorssite_cr": v.Name,
 		"tier":            tier,
 	}
 }
+
+// image renders the image reference for a tier, falling back to the given
+// repository and tag when they are not set in the spec.
+func image(spec examplecomv1.ImageSpec, defaultRepository string, defaultTag string) string {
+	repository := spec.Repository
+	if repository == "" {
+		repository = defaultRepository
+	}
+
+	if spec.Digest != "" {
+		return repository + "@" + spec.Digest
+	}
+
+	tag := spec.Tag
+	if tag == "" {
+		tag = defaultTag
+	}
+	return repository + ":" + tag
+}
+
+// resources returns the resources set for a tier in the spec, or only a CPU
+// request when neither requests nor limits are set.
+func resources(spec corev1.ResourceRequirements, defaultCPURequest string) corev1.ResourceRequirements {
+	if len(spec.Requests) > 0 || len(spec.Limits) > 0 {
+		return spec
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse(defaultCPURequest),
+		},
+	}
+}
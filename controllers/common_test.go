@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("Applied objects", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		r        *VisitorsAppReconciler
+		recorder *record.FakeRecorder
+		req      ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "applied", Namespace: "default", UID: "applied-uid", Generation: 1},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{Size: 3}},
+			},
+		}
+		r = newTestReconciler(app)
+		recorder = r.Recorder.(*record.FakeRecorder)
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}}
+	})
+
+	AfterEach(func() {
+		deleteMetrics(req.NamespacedName)
+	})
+
+	ensureBackend := func() *appsv1.Deployment {
+		result, err := r.ensureDeployment(ctx, req, app, r.backendDeployment(app))
+		Expect(result).To(BeNil())
+		Expect(err).NotTo(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: backendDeploymentName(app), Namespace: app.Namespace}, dep)).To(Succeed())
+		return dep
+	}
+
+	It("puts back the fields of a Deployment edited by hand", func() {
+		ensureBackend()
+		Expect(recorder.Events).To(Receive(Equal("Normal Created Created Deployment applied-backend")))
+
+		dep := ensureBackend()
+		Expect(recorder.Events).NotTo(Receive())
+
+		dep.Spec.Template.Spec.Containers[0].Image = "elsewhere/visitors-service"
+		Expect(r.Update(ctx, dep)).To(Succeed())
+
+		dep = ensureBackend()
+		Expect(dep.Spec.Template.Spec.Containers[0].Image).To(Equal(r.backendDeployment(app).Spec.Template.Spec.Containers[0].Image))
+		Expect(recorder.Events).To(Receive(Equal("Normal Updated Updated Deployment applied-backend to match the VisitorsApp")))
+		Expect(testutil.ToFloat64(driftCorrectionsCounter.WithLabelValues("default", "applied", "Deployment",
+			"spec.template.spec.containers[visitors-service].image"))).To(Equal(1.0))
+	})
+
+	It("hands the replicas over to the autoscaler when auto-scaling is enabled", func() {
+		Expect(*ensureBackend().Spec.Replicas).To(Equal(int32(3)))
+
+		// Until the autoscaler scaled the Deployment, the current replicas are
+		// applied so that they are not reset
+		app.Spec.Backend.AutoScaling = examplecomv1.AutoScalingSpec{Enabled: true}
+		Expect(r.backendDeployment(app).Spec.Replicas).To(BeNil())
+		dep := ensureBackend()
+		Expect(*dep.Spec.Replicas).To(Equal(int32(3)))
+		Expect(lastApplied(r, "Deployment", dep.Name).(*appsv1.Deployment).Spec.Replicas).To(PointTo(Equal(int32(3))))
+
+		scaled := int32(5)
+		dep.Spec.Replicas = &scaled
+		dep.ManagedFields = []metav1.ManagedFieldsEntry{{
+			Manager:     "kube-controller-manager",
+			Operation:   metav1.ManagedFieldsOperationUpdate,
+			APIVersion:  "apps/v1",
+			FieldsType:  "FieldsV1",
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)},
+			Subresource: "scale",
+		}}
+		Expect(r.Update(ctx, dep)).To(Succeed())
+
+		dep = ensureBackend()
+		Expect(*dep.Spec.Replicas).To(Equal(int32(5)))
+		Expect(lastApplied(r, "Deployment", dep.Name).(*appsv1.Deployment).Spec.Replicas).To(BeNil())
+	})
+	ensureBackendService := func() *corev1.Service {
+		result, err := r.ensureService(ctx, req, app, r.backendService(app))
+		Expect(result).To(BeNil())
+		Expect(err).NotTo(HaveOccurred())
+
+		s := &corev1.Service{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: backendServiceName(app), Namespace: app.Namespace}, s)).To(Succeed())
+		return s
+	}
+
+	It("clears the node ports of a NodePort Service becoming a ClusterIP Service", func() {
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{Type: corev1.ServiceTypeNodePort, NodePort: 30685}
+		Expect(ensureBackendService().Spec.Ports[0].NodePort).To(Equal(int32(30685)))
+
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}
+		s := ensureBackendService()
+		Expect(s.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+		Expect(s.Spec.Ports[0].NodePort).To(BeZero())
+		Expect(lastUpdatedBy(r, "Service", s.Name)).To(Equal(fieldManager))
+	})
+
+	It("clears the fields allocated for a LoadBalancer Service becoming a ClusterIP Service", func() {
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{
+			Type:                     corev1.ServiceTypeLoadBalancer,
+			ExternalTrafficPolicy:    corev1.ServiceExternalTrafficPolicyTypeLocal,
+			LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+		}
+		s := ensureBackendService()
+
+		// Allocated by the API server
+		s.Spec.Ports[0].NodePort = 31234
+		s.Spec.HealthCheckNodePort = 31235
+		Expect(r.Update(ctx, s)).To(Succeed())
+
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}
+		s = ensureBackendService()
+		Expect(s.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+		Expect(s.Spec.Ports[0].NodePort).To(BeZero())
+		Expect(s.Spec.HealthCheckNodePort).To(BeZero())
+		Expect(s.Spec.ExternalTrafficPolicy).To(BeEmpty())
+		Expect(s.Spec.LoadBalancerSourceRanges).To(BeEmpty())
+		Expect(lastUpdatedBy(r, "Service", s.Name)).To(Equal(fieldManager))
+	})
+
+	It("leaves the node port of a ClusterIP Service becoming a NodePort Service to the API server", func() {
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}
+		ensureBackendService()
+
+		app.Spec.Backend.Service = examplecomv1.ServiceSpec{Type: corev1.ServiceTypeNodePort}
+		s := ensureBackendService()
+		Expect(s.Spec.Type).To(Equal(corev1.ServiceTypeNodePort))
+		Expect(lastApplied(r, "Service", s.Name).(*corev1.Service).Spec.Ports[0].NodePort).To(BeZero())
+		// Only a Service becoming a ClusterIP Service is updated before the apply
+		Expect(lastUpdatedBy(r, "Service", s.Name)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const mysqlPort = 3306
+
+// DatabaseStatus is the outcome of a DatabaseChecker, reported in the
+// DatabaseReady condition
+type DatabaseStatus struct {
+	Ready   bool
+	Reason  string
+	Message string
+}
+
+// DatabaseChecker tells whether the MySQL database of a VisitorsApp can be
+// used by the backend
+type DatabaseChecker interface {
+	CheckDatabase(ctx context.Context, v *examplecomv1.VisitorsApp) DatabaseStatus
+}
+
+// DatabaseCheckers runs checks in order and reports the first one failing
+type DatabaseCheckers []DatabaseChecker
+
+func (checkers DatabaseCheckers) CheckDatabase(ctx context.Context, v *examplecomv1.VisitorsApp) DatabaseStatus {
+	var status DatabaseStatus
+	for _, checker := range checkers {
+		status = checker.CheckDatabase(ctx, v)
+		if !status.Ready {
+			return status
+		}
+	}
+	return status
+}
+
+// StatefulSetChecker considers the database ready once the MySQL StatefulSet
+// has a ready replica
+type StatefulSetChecker struct {
+	Reader client.Reader
+}
+
+func (c *StatefulSetChecker) CheckDatabase(ctx context.Context, v *examplecomv1.VisitorsApp) DatabaseStatus {
+	name := mysqlNamespace(v) + "/" + mysqlStatefulSetName(v)
+
+	statefulset := &appsv1.StatefulSet{}
+	err := c.Reader.Get(ctx, types.NamespacedName{
+		Name:      mysqlStatefulSetName(v),
+		Namespace: mysqlNamespace(v),
+	}, statefulset)
+	if errors.IsNotFound(err) {
+		return DatabaseStatus{Reason: "MysqlNotFound", Message: fmt.Sprintf("StatefulSet %s is missing", name)}
+	}
+	if err != nil {
+		return DatabaseStatus{Reason: "MysqlNotReady", Message: fmt.Sprintf("Failed to get StatefulSet %s: %s", name, err)}
+	}
+
+	if statefulset.Status.ReadyReplicas < 1 {
+		return DatabaseStatus{Reason: "MysqlNotReady", Message: fmt.Sprintf("StatefulSet %s has no ready replicas", name)}
+	}
+	return DatabaseStatus{Ready: true, Reason: "MysqlReady", Message: fmt.Sprintf("StatefulSet %s has ready replicas", name)}
+}
+
+// TCPChecker considers the database ready once a connection to its read-write
+// service can be opened. It needs the operator to run in the cluster, where
+// the service names resolve.
+type TCPChecker struct {
+	Timeout time.Duration
+	// Address returns the host:port to dial, the read-write service of the
+	// MySQL cluster when it is nil
+	Address func(v *examplecomv1.VisitorsApp) string
+}
+
+func (c *TCPChecker) CheckDatabase(ctx context.Context, v *examplecomv1.VisitorsApp) DatabaseStatus {
+	address := mysqlAddress(v)
+	if c.Address != nil {
+		address = c.Address(v)
+	}
+
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return DatabaseStatus{Reason: "MysqlUnreachable", Message: fmt.Sprintf("Failed to connect to %s: %s", address, err)}
+	}
+	conn.Close()
+
+	return DatabaseStatus{Ready: true, Reason: "MysqlReachable", Message: fmt.Sprintf("Connected to %s", address)}
+}
+
+// Returns the address of the read-write service of the MySQL cluster, as seen
+// from any namespace
+func mysqlAddress(v *examplecomv1.VisitorsApp) string {
+	host := mysqlServiceRWName(v) + "." + mysqlNamespace(v) + ".svc"
+	return net.JoinHostPort(host, strconv.Itoa(mysqlPort))
+}
+
+// Returns the checker of the reconciler, the StatefulSet check by default
+func (r *VisitorsAppReconciler) databaseChecker() DatabaseChecker {
+	if r.DatabaseChecker != nil {
+		return r.DatabaseChecker
+	}
+	return &StatefulSetChecker{Reader: r.Client}
+}
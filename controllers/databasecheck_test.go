@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Database checks", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "checks", Namespace: "default"},
+		}
+	})
+
+	It("reports a missing or unready StatefulSet", func() {
+		statefulset := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: mysqlStatefulSetName(app), Namespace: app.Namespace},
+		}
+		c := newTestReconciler().Client
+		checker := &StatefulSetChecker{Reader: c}
+
+		Expect(checker.CheckDatabase(ctx, app)).To(Equal(DatabaseStatus{
+			Reason:  "MysqlNotFound",
+			Message: "StatefulSet default/my-cluster-mysql is missing",
+		}))
+
+		Expect(c.Create(ctx, statefulset)).To(Succeed())
+		Expect(checker.CheckDatabase(ctx, app).Reason).To(Equal("MysqlNotReady"))
+
+		statefulset.Status.ReadyReplicas = 1
+		Expect(c.Status().Update(ctx, statefulset)).To(Succeed())
+		Expect(checker.CheckDatabase(ctx, app).Ready).To(BeTrue())
+	})
+
+	It("dials the database", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+
+		checker := &TCPChecker{
+			Timeout: time.Second,
+			Address: func(*examplecomv1.VisitorsApp) string { return address },
+		}
+		Expect(checker.CheckDatabase(ctx, app)).To(Equal(DatabaseStatus{
+			Ready:   true,
+			Reason:  "MysqlReachable",
+			Message: "Connected to " + address,
+		}))
+
+		Expect(listener.Close()).To(Succeed())
+		status := checker.CheckDatabase(ctx, app)
+		Expect(status.Ready).To(BeFalse())
+		Expect(status.Reason).To(Equal("MysqlUnreachable"))
+	})
+
+	It("reports the first failing check", func() {
+		checkers := DatabaseCheckers{
+			&StatefulSetChecker{Reader: newTestReconciler().Client},
+			&TCPChecker{Address: func(*examplecomv1.VisitorsApp) string { return "" }},
+		}
+		Expect(checkers.CheckDatabase(ctx, app).Reason).To(Equal("MysqlNotFound"))
+	})
+
+	It("dials the read-write service of the cluster", func() {
+		app.Spec.Database.Namespace = "databases"
+		Expect(mysqlAddress(app)).To(Equal("my-cluster-mysql-master.databases.svc:3306"))
+	})
+})
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the Events recorded on a VisitorsApp, and on its backups and restores
+const (
+	eventReasonCreated               = "Created"
+	eventReasonUpdated               = "Updated"
+	eventReasonDeleted               = "Deleted"
+	eventReasonOrphaned              = "Orphaned"
+	eventReasonScaled                = "Scaled"
+	eventReasonImageChanged          = "ImageChanged"
+	eventReasonCredentialsChanged    = "CredentialsChanged"
+	eventReasonWaitingForDatabase    = "WaitingForDatabase"
+	eventReasonWaitingForBackend     = "WaitingForBackend"
+	eventReasonDatabaseDropped       = "DatabaseDropped"
+	eventReasonDatabaseDropFailed    = "DatabaseDropFailed"
+	eventReasonSchemaMigrated        = "SchemaMigrated"
+	eventReasonSchemaMigrationFailed = "SchemaMigrationFailed"
+	eventReasonReady                 = "Ready"
+	eventReasonNotReady              = "NotReady"
+	eventReasonReconcileFailed       = "ReconcileFailed"
+	eventReasonCompleted             = "Completed"
+	eventReasonFailed                = "Failed"
+	eventReasonBackupScheduled       = "BackupScheduled"
+	eventReasonBackupPruned          = "BackupPruned"
+	eventReasonDumpRemoved           = "DumpRemoved"
+	eventReasonDumpRemoveFailed      = "DumpRemoveFailed"
+	eventReasonRolloutStarted        = "RolloutStarted"
+	eventReasonRolloutCompleted      = "RolloutCompleted"
+	eventReasonRolloutAborted        = "RolloutAborted"
+	eventReasonRolledBack            = "RolledBack"
+)
+
+// Returns "<Kind> <name>" for the messages of the Events about obj
+func describe(kind string, obj client.Object) string {
+	return kind + " " + obj.GetName()
+}
+
+// Records the replicas, image and credentials changes of a Deployment that was just applied.
+// old is the Deployment as it was before.
+func (r *VisitorsAppReconciler) recordDeploymentChanges(v *examplecomv1.VisitorsApp, old *appsv1.Deployment, dep *appsv1.Deployment) {
+	if old.Spec.Replicas != nil && dep.Spec.Replicas != nil && *old.Spec.Replicas != *dep.Spec.Replicas {
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonScaled, "Scaled %s from %d to %d replicas",
+			describe("Deployment", dep), *old.Spec.Replicas, *dep.Spec.Replicas)
+	}
+
+	oldContainers := old.Spec.Template.Spec.Containers
+	containers := dep.Spec.Template.Spec.Containers
+	if len(oldContainers) > 0 && len(containers) > 0 && oldContainers[0].Image != containers[0].Image {
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonImageChanged, "Changed the image of %s from %s to %s",
+			describe("Deployment", dep), oldContainers[0].Image, containers[0].Image)
+	}
+
+	oldHash := old.Spec.Template.Annotations[credentialsHashAnnotation]
+	hash := dep.Spec.Template.Annotations[credentialsHashAnnotation]
+	if oldHash != "" && hash != "" && oldHash != hash {
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonCredentialsChanged,
+			"Restarting the pods of %s to pick up the new database credentials", describe("Deployment", dep))
+	}
+}
+
+// Records the transitions of the Ready condition between two versions of the status
+func (r *VisitorsAppReconciler) recordReadyTransition(original *examplecomv1.VisitorsApp, v *examplecomv1.VisitorsApp) {
+	ready := meta.FindStatusCondition(v.Status.Conditions, examplecomv1.ConditionReady)
+	if ready == nil {
+		return
+	}
+
+	previous := meta.FindStatusCondition(original.Status.Conditions, examplecomv1.ConditionReady)
+	if previous != nil && previous.Status == ready.Status {
+		return
+	}
+
+	if meta.IsStatusConditionTrue(v.Status.Conditions, examplecomv1.ConditionReady) {
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonReady, ready.Message)
+	} else if previous != nil {
+		// A VisitorsApp that is still coming up is not reported as NotReady
+		r.Recorder.Event(v, corev1.EventTypeWarning, eventReasonNotReady, ready.Message)
+	}
+}
+
+func (r *VisitorsAppReconciler) recordReconcileFailed(v *examplecomv1.VisitorsApp, err error) {
+	r.Recorder.Event(v, corev1.EventTypeWarning, eventReasonReconcileFailed, fmt.Sprintf("Reconcile failed: %s", err))
+}
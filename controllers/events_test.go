@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("VisitorsApp events", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		recorder *record.FakeRecorder
+		r        *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "events",
+				Namespace: "default",
+				UID:       types.UID("events-uid"),
+			},
+		}
+
+		hpa := newHorizontalPodAutoscaler(backendHorizontalPodAutoscalerName(app), app.Namespace)
+		hpa.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(app, examplecomv1.GroupVersion.WithKind("VisitorsApp"))}
+
+		r = newTestReconciler(app, hpa)
+		recorder = r.Recorder.(*record.FakeRecorder)
+	})
+
+	It("reports waiting for the database", func() {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Normal WaitingForDatabase MySQL isn't running, waiting for 5s")))
+	})
+
+	It("reports the deletion of an autoscaler", func() {
+		result, err := r.ensureHorizontalPodAutoscaler(ctx, app, "backend",
+			backendHorizontalPodAutoscalerName(app), backendDeploymentName(app), nil)
+		Expect(result).To(BeNil())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Normal Deleted Deleted HorizontalPodAutoscaler events-backend-hpa")))
+
+		err = r.Get(ctx, types.NamespacedName{
+			Name:      backendHorizontalPodAutoscalerName(app),
+			Namespace: app.Namespace,
+		}, &autoscalingv2.HorizontalPodAutoscaler{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports scaling and image changes of a Deployment", func() {
+		old := r.backendDeployment(app)
+		dep := old.DeepCopy()
+		replicas := int32(3)
+		dep.Spec.Replicas = &replicas
+		dep.Spec.Template.Spec.Containers[0].Image = "kerryduan/visitors-service:1.1.0"
+
+		r.recordDeploymentChanges(app, old, dep)
+		Expect(recorder.Events).To(Receive(Equal("Normal Scaled Scaled Deployment events-backend from 1 to 3 replicas")))
+		Expect(recorder.Events).To(Receive(Equal("Normal ImageChanged Changed the image of Deployment events-backend " +
+			"from kerryduan/visitors-service:1.0.0 to kerryduan/visitors-service:1.1.0")))
+	})
+
+	It("restarts the backend when the database credentials are rotated", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: app.Namespace},
+			Data:       map[string][]byte{"USER": []byte("visitors"), "PASSWORD": []byte("old")},
+		}
+		Expect(r.Create(ctx, secret)).To(Succeed())
+
+		old, err := r.backendDeploymentWithCredentials(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(old.Spec.Template.Annotations).To(HaveKey(credentialsHashAnnotation))
+
+		secret.Data["PASSWORD"] = []byte("new")
+		Expect(r.Update(ctx, secret)).To(Succeed())
+
+		dep, err := r.backendDeploymentWithCredentials(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dep.Spec.Template.Annotations[credentialsHashAnnotation]).NotTo(Equal(old.Spec.Template.Annotations[credentialsHashAnnotation]))
+
+		r.recordDeploymentChanges(app, old, dep)
+		Expect(recorder.Events).To(Receive(Equal("Normal CredentialsChanged Restarting the pods of Deployment events-backend " +
+			"to pick up the new database credentials")))
+	})
+
+	It("reports the Ready transitions", func() {
+		original := app.DeepCopy()
+		setCondition(app, examplecomv1.ConditionReady, metav1.ConditionFalse, "DatabaseReadyNotTrue", "DatabaseReady is not True")
+		r.recordReadyTransition(original, app)
+		Expect(recorder.Events).NotTo(Receive())
+
+		original = app.DeepCopy()
+		setCondition(app, examplecomv1.ConditionReady, metav1.ConditionTrue, "AllComponentsReady", "All components are ready")
+		r.recordReadyTransition(original, app)
+		Expect(recorder.Events).To(Receive(Equal("Normal Ready All components are ready")))
+
+		original = app.DeepCopy()
+		setCondition(app, examplecomv1.ConditionReady, metav1.ConditionFalse, "BackendAvailableNotTrue", "BackendAvailable is not True")
+		r.recordReadyTransition(original, app)
+		Expect(recorder.Events).To(Receive(Equal("Warning NotReady BackendAvailable is not True")))
+	})
+})
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Finalizer that lets the operator apply the deletion policy before the
+// owned objects are garbage collected
+const visitorsAppFinalizer = "example.com.my.domain/finalizer"
+
+func deletionPolicy(v *examplecomv1.VisitorsApp) examplecomv1.DeletionPolicy {
+	if v.Spec.DeletionPolicy != "" {
+		return v.Spec.DeletionPolicy
+	}
+	return examplecomv1.DeletionPolicyDelete
+}
+
+func mysqlDropJobName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-db-drop"
+}
+
+// Adds the finalizer to a VisitorsApp that does not have it yet
+func (r *VisitorsAppReconciler) ensureFinalizer(ctx context.Context, v *examplecomv1.VisitorsApp) error {
+	log := ctrllog.FromContext(ctx)
+
+	if controllerutil.ContainsFinalizer(v, visitorsAppFinalizer) {
+		return nil
+	}
+
+	controllerutil.AddFinalizer(v, visitorsAppFinalizer)
+	err := r.Update(ctx, v)
+	if err != nil {
+		log.Error(err, "Failed to add the finalizer")
+	}
+	return err
+}
+
+// Applies the deletion policy of a VisitorsApp that is being deleted, then
+// removes the finalizer so that the remaining owned objects are garbage collected
+func (r *VisitorsAppReconciler) finalize(ctx context.Context, v *examplecomv1.VisitorsApp) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(v, visitorsAppFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	policy := deletionPolicy(v)
+	if policy != examplecomv1.DeletionPolicyDelete {
+		if v.Spec.Database.Managed && v.Spec.Database.DropOnDelete {
+			result, err := r.ensureDatabaseDropped(ctx, v)
+			if result != nil {
+				return *result, err
+			}
+		}
+
+		for _, obj := range retainedObjects(v, policy) {
+			result, err := r.ensureOrphaned(ctx, v, obj)
+			if result != nil {
+				return *result, err
+			}
+		}
+	}
+
+	log.Info("Removing the finalizer", "DeletionPolicy", policy)
+	controllerutil.RemoveFinalizer(v, visitorsAppFinalizer)
+	err := r.Update(ctx, v)
+	if err != nil {
+		log.Error(err, "Failed to remove the finalizer")
+		return ctrl.Result{}, err
+	}
+
+	deleteMetrics(client.ObjectKeyFromObject(v))
+	return ctrl.Result{}, nil
+}
+
+// Returns the owned objects that outlive the VisitorsApp under the given policy
+func retainedObjects(v *examplecomv1.VisitorsApp, policy examplecomv1.DeletionPolicy) []client.Object {
+	objs := []client.Object{
+		newService(backendServiceName(v), v.Namespace),
+		newService(frontendServiceName(v), v.Namespace),
+	}
+
+	if v.Spec.Database.Managed {
+		cluster := newMysqlCluster()
+		cluster.SetName(mysqlClusterName(v))
+		cluster.SetNamespace(v.Namespace)
+		objs = append(objs, cluster, &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      mysqlAuthName(v),
+				Namespace: v.Namespace,
+			},
+		})
+	}
+
+	if policy == examplecomv1.DeletionPolicyOrphan {
+		objs = append(objs,
+			newDeployment(backendDeploymentName(v), v.Namespace),
+			newDeployment(frontendDeploymentName(v), v.Namespace),
+			newDeployment(backendCanaryDeploymentName(v), v.Namespace),
+			newDeployment(backendPreviewDeploymentName(v), v.Namespace),
+			newHorizontalPodAutoscaler(backendHorizontalPodAutoscalerName(v), v.Namespace),
+			newHorizontalPodAutoscaler(frontendHorizontalPodAutoscalerName(v), v.Namespace),
+			newIngress(v),
+		)
+	}
+
+	return objs
+}
+
+func newService(name string, namespace string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+func newDeployment(name string, namespace string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+// Runs a Job dropping the visitors database and user from a managed cluster
+// that is retained, and waits for it to finish. A failed Job is logged but
+// does not block the deletion of the VisitorsApp.
+func (r *VisitorsAppReconciler) ensureDatabaseDropped(ctx context.Context, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	delay := time.Second * time.Duration(5)
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      mysqlDropJobName(v),
+		Namespace: v.Namespace,
+	}, job)
+	if err != nil && errors.IsNotFound(err) {
+		job, err = r.mysqlDropJob(ctx, v)
+		if err != nil {
+			log.Error(err, "Failed to render the Job dropping the database")
+			return &ctrl.Result{}, err
+		}
+
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return &ctrl.Result{}, err
+		}
+		return &ctrl.Result{RequeueAfter: delay}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Job")
+		return &ctrl.Result{}, err
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			log.Info("Dropped the database", "Database", mysqlDatabaseName(v))
+			r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonDatabaseDropped, "Dropped database %s", mysqlDatabaseName(v))
+			return nil, nil
+		case batchv1.JobFailed:
+			log.Error(fmt.Errorf("%s", c.Message), "Failed to drop the database, it is kept",
+				"Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			r.Recorder.Eventf(v, corev1.EventTypeWarning, eventReasonDatabaseDropFailed, "Failed to drop database %s, it is kept: %s",
+				mysqlDatabaseName(v), c.Message)
+			return nil, nil
+		}
+	}
+
+	log.Info(fmt.Sprintf("Waiting %s for the database to be dropped", delay))
+	return &ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// Renders the Job running the DROP statements with the mysql client as root.
+// The user name is read from the credentials Secret the cluster was created with.
+func (r *VisitorsAppReconciler) mysqlDropJob(ctx context.Context, v *examplecomv1.VisitorsApp) (*batchv1.Job, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      mysqlAuthName(v),
+		Namespace: v.Namespace,
+	}, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := fmt.Sprintf("DROP DATABASE IF EXISTS %s;", quoteIdentifier(mysqlDatabaseName(v)))
+	if user := string(secret.Data[mysqlUserKey(v)]); user != "" {
+		statements += fmt.Sprintf(" DROP USER IF EXISTS %s@'%%';", quoteString(user))
+	}
+
+	labels := labels(v, "database")
+	backoffLimit := int32(3)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mysqlDropJobName(v),
+			Namespace: v.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:  "mysql",
+						Image: "mysql:" + mysqlVersion(v),
+						Command: []string{
+							"mysql",
+							"-h", mysqlServiceHost(v, mysqlServiceRWName(v)),
+							"-u", "root",
+							"-e", statements,
+						},
+						Env: []corev1.EnvVar{{
+							Name: "MYSQL_PWD",
+							ValueFrom: &corev1.EnvVarSource{
+								SecretKeyRef: &corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName(v)},
+									Key:                  mysqlRootPasswordKey(v),
+								},
+							},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	controllerutil.SetControllerReference(v, job, r.Scheme)
+	return job, nil
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("VisitorsApp deletion", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		r        *VisitorsAppReconciler
+		recorder *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "visitors",
+				Namespace:  "default",
+				UID:        "visitors-uid",
+				Finalizers: []string{visitorsAppFinalizer},
+			},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{Managed: true},
+			},
+		}
+
+		owned := func(obj client.Object) client.Object {
+			obj.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(app, examplecomv1.GroupVersion.WithKind("VisitorsApp"))})
+			return obj
+		}
+		cluster := newMysqlCluster()
+		cluster.SetName(mysqlClusterName(app))
+		cluster.SetNamespace(app.Namespace)
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: app.Namespace},
+			Data:       map[string][]byte{"USER": []byte("visitors-user")},
+		}
+
+		r = newTestReconciler(app,
+			owned(newService(backendServiceName(app), app.Namespace)),
+			owned(newService(frontendServiceName(app), app.Namespace)),
+			owned(newDeployment(backendDeploymentName(app), app.Namespace)),
+			owned(newDeployment(frontendDeploymentName(app), app.Namespace)),
+			owned(newDeployment(backendCanaryDeploymentName(app), app.Namespace)),
+			owned(cluster),
+			owned(secret),
+		)
+		recorder = r.Recorder.(*record.FakeRecorder)
+	})
+
+	// Runs the finalizer of the VisitorsApp as it is stored
+	finalizeApp := func() ctrl.Result {
+		v := &examplecomv1.VisitorsApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), v)).To(Succeed())
+		Expect(v.DeletionTimestamp).NotTo(BeNil())
+
+		result, err := r.finalize(ctx, v)
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	// Deletes the VisitorsApp with the given policy and runs the finalizer
+	deleteApp := func(policy examplecomv1.DeletionPolicy) ctrl.Result {
+		app.Spec.DeletionPolicy = policy
+		Expect(r.Update(ctx, app)).To(Succeed())
+		Expect(r.Delete(ctx, app)).To(Succeed())
+		return finalizeApp()
+	}
+
+	// Returns whether the object named like obj is still controlled by the VisitorsApp
+	controlled := func(obj client.Object) bool {
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(obj), obj)).To(Succeed())
+		return metav1.IsControlledBy(obj, app)
+	}
+
+	finalized := func() bool {
+		v := &examplecomv1.VisitorsApp{}
+		err := r.Get(ctx, client.ObjectKeyFromObject(app), v)
+		return client.IgnoreNotFound(err) == nil && len(v.Finalizers) == 0
+	}
+
+	clusterObject := func() client.Object {
+		cluster := newMysqlCluster()
+		cluster.SetName(mysqlClusterName(app))
+		cluster.SetNamespace(app.Namespace)
+		return cluster
+	}
+
+	It("removes the finalizer at once with the Delete policy", func() {
+		Expect(deleteApp(examplecomv1.DeletionPolicyDelete)).To(Equal(ctrl.Result{}))
+		Expect(finalized()).To(BeTrue())
+		Expect(controlled(newService(backendServiceName(app), app.Namespace))).To(BeTrue())
+		Expect(controlled(clusterObject())).To(BeTrue())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("keeps the Services and the database with the Retain policy", func() {
+		deleteApp(examplecomv1.DeletionPolicyRetain)
+		Expect(finalized()).To(BeTrue())
+
+		Expect(controlled(newService(backendServiceName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(newService(frontendServiceName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(clusterObject())).To(BeFalse())
+		Expect(controlled(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: app.Namespace}})).To(BeFalse())
+
+		Expect(controlled(newDeployment(backendDeploymentName(app), app.Namespace))).To(BeTrue())
+		Expect(controlled(newDeployment(frontendDeploymentName(app), app.Namespace))).To(BeTrue())
+	})
+
+	It("keeps every object with the Orphan policy", func() {
+		deleteApp(examplecomv1.DeletionPolicyOrphan)
+		Expect(finalized()).To(BeTrue())
+
+		Expect(controlled(newService(backendServiceName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(newService(frontendServiceName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(clusterObject())).To(BeFalse())
+		Expect(controlled(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: app.Namespace}})).To(BeFalse())
+		Expect(controlled(newDeployment(backendDeploymentName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(newDeployment(frontendDeploymentName(app), app.Namespace))).To(BeFalse())
+		Expect(controlled(newDeployment(backendCanaryDeploymentName(app), app.Namespace))).To(BeFalse())
+	})
+
+	Context("with the database dropped on deletion", func() {
+		var job *batchv1.Job
+
+		BeforeEach(func() {
+			app.Spec.Database.DropOnDelete = true
+			Expect(deleteApp(examplecomv1.DeletionPolicyRetain).RequeueAfter).NotTo(BeZero())
+			Expect(finalized()).To(BeFalse())
+
+			job = &batchv1.Job{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: mysqlDropJobName(app), Namespace: app.Namespace}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(
+				"DROP DATABASE IF EXISTS `visitors_db`; DROP USER IF EXISTS 'visitors-user'@'%';"))
+
+			// The Job is waited for
+			Expect(finalizeApp().RequeueAfter).NotTo(BeZero())
+			Expect(finalized()).To(BeFalse())
+		})
+
+		finishJob := func(condition batchv1.JobConditionType) {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+			Expect(r.Status().Update(ctx, job)).To(Succeed())
+		}
+
+		It("removes the finalizer once the database was dropped", func() {
+			finishJob(batchv1.JobComplete)
+			finalizeApp()
+			Expect(finalized()).To(BeTrue())
+			Expect(recorder.Events).To(Receive(Equal("Normal DatabaseDropped Dropped database visitors_db")))
+		})
+
+		It("removes the finalizer when the database could not be dropped", func() {
+			finishJob(batchv1.JobFailed)
+			finalizeApp()
+			Expect(finalized()).To(BeTrue())
+			Expect(recorder.Events).To(Receive(Equal(
+				"Warning DatabaseDropFailed Failed to drop database visitors_db, it is kept: BackoffLimitExceeded")))
+		})
+	})
+})
@@ -2,36 +2,72 @@ package controllers
 
 import (
 	"context"
-	"time"
+	"fmt"
 
-	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
-	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const frontendPort = 3000
-const frontendImage = "jdob/visitors-webui:1.0.0"
 
-func frontendDeploymentName(v *examplecomv1beta1.VisitorsApp) string {
+func frontendDeploymentName(v *examplecomv1.VisitorsApp) string {
 	return v.Name + "-frontend"
 }
 
-func frontendServiceName(v *examplecomv1beta1.VisitorsApp) string {
+func frontendServiceName(v *examplecomv1.VisitorsApp) string {
 	return v.Name + "-frontend-service"
 }
 
-func (r *VisitorsAppReconciler) frontendDeployment(v *examplecomv1beta1.VisitorsApp) *appsv1.Deployment {
+func frontendHorizontalPodAutoscalerName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-frontend-hpa"
+}
+
+// An operator-managed autoscaler implies auto-scaling
+func frontendAutoScaling(v *examplecomv1.VisitorsApp) bool {
+	return v.Spec.Frontend.AutoScaling.Enabled || v.Spec.Frontend.AutoScaling.HorizontalPodAutoscaler != nil
+}
+
+func frontendSize(v *examplecomv1.VisitorsApp) int32 {
+	if v.Spec.Frontend.Size != 0 {
+		return v.Spec.Frontend.Size
+	}
+	return examplecomv1.DefaultSize
+}
+
+func frontendImage(v *examplecomv1.VisitorsApp) string {
+	return image(v.Spec.Frontend.Image, examplecomv1.DefaultFrontendImageRepository, examplecomv1.DefaultFrontendImageTag)
+}
+
+// An empty pull policy leaves the choice to the API server defaults
+func frontendImagePullPolicy(v *examplecomv1.VisitorsApp) corev1.PullPolicy {
+	return v.Spec.Frontend.Image.PullPolicy
+}
+
+func frontendResources(v *examplecomv1.VisitorsApp) corev1.ResourceRequirements {
+	return resources(v.Spec.Frontend.Resources, examplecomv1.DefaultFrontendCPURequest)
+}
+
+func (r *VisitorsAppReconciler) frontendDeployment(v *examplecomv1.VisitorsApp) *appsv1.Deployment {
 	labels := labels(v, "frontend")
-	frontendTitle := v.Spec.FrontendTitle
-	frontendSize := v.Spec.FrontendSize
+	frontendTitle := v.Spec.Frontend.Title
+	if frontendTitle == "" {
+		frontendTitle = examplecomv1.DefaultFrontendTitle
+	}
+	frontendSize := frontendSize(v)
+
+	// With auto-scaling enabled the replicas are left to the
+	// HorizontalPodAutoscaler, once ensureDeployment handed them over
+	var replicas *int32
+	if !frontendAutoScaling(v) {
+		replicas = &frontendSize
+	}
 
 	// If the header was specified, add it as an env variable
 	env := []corev1.EnvVar{}
@@ -40,12 +76,17 @@ func (r *VisitorsAppReconciler) frontendDeployment(v *examplecomv1beta1.Visitors
 		Value: frontendTitle,
 	})
 	dep := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      frontendDeploymentName(v),
 			Namespace: v.Namespace,
+			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &frontendSize,
+			Replicas: replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -55,18 +96,18 @@ func (r *VisitorsAppReconciler) frontendDeployment(v *examplecomv1beta1.Visitors
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
-						Image: frontendImage,
-						Name:  "visitors-webui",
+						Image:           frontendImage(v),
+						ImagePullPolicy: frontendImagePullPolicy(v),
+						Name:            "visitors-webui",
 						Ports: []corev1.ContainerPort{{
 							ContainerPort: frontendPort,
 							Name:          "visitors",
 						}},
-						Env: env,
-						Resources: corev1.ResourceRequirements{
-							Requests: corev1.ResourceList{
-								"cpu": resource.MustParse("500m"),
-							},
-						},
+						Env:            env,
+						Resources:      frontendResources(v),
+						StartupProbe:   probe(v.Spec.Frontend.StartupProbe, startupProbe(httpProbeHandler("/", frontendPort))),
+						LivenessProbe:  probe(v.Spec.Frontend.LivenessProbe, livenessProbe(httpProbeHandler("/", frontendPort))),
+						ReadinessProbe: probe(v.Spec.Frontend.ReadinessProbe, readinessProbe(httpProbeHandler("/", frontendPort))),
 					}},
 				},
 			},
@@ -77,14 +118,18 @@ func (r *VisitorsAppReconciler) frontendDeployment(v *examplecomv1beta1.Visitors
 	return dep
 }
 
-func (r *VisitorsAppReconciler) frontendService(v *examplecomv1beta1.VisitorsApp) *corev1.Service {
+func (r *VisitorsAppReconciler) frontendService(v *examplecomv1.VisitorsApp) *corev1.Service {
 	labels := labels(v, "frontend")
-	frontendServiceNodePort := v.Spec.FrontendServiceNodePort
 
 	s := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      frontendServiceName(v),
 			Namespace: v.Namespace,
+			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: labels,
@@ -92,88 +137,54 @@ func (r *VisitorsAppReconciler) frontendService(v *examplecomv1beta1.VisitorsApp
 				Protocol:   corev1.ProtocolTCP,
 				Port:       frontendPort,
 				TargetPort: intstr.FromInt(int(frontendPort)),
-				NodePort:   frontendServiceNodePort,
 			}},
-			Type: corev1.ServiceTypeNodePort,
 		},
 	}
+	exposeService(s, v.Spec.Frontend.Service)
 
 	controllerutil.SetControllerReference(v, s, r.Scheme)
 	return s
 }
 
-func (r *VisitorsAppReconciler) updateFrontendStatus(ctx context.Context, v *examplecomv1beta1.VisitorsApp) error {
-	v.Status.FrontendImage = frontendImage
-	err := r.Update(ctx, v)
-	return err
-}
-
-func (r *VisitorsAppReconciler) handleFrontendChanges(ctx context.Context, v *examplecomv1beta1.VisitorsApp) (*ctrl.Result, error) {
-	log := ctrllog.FromContext(ctx)
-
-	foundDeployment := &appsv1.Deployment{}
-	foundService := &corev1.Service{}
-
+// Fills the frontend part of the status from what the Deployment and Service
+// are actually running with. Objects that do not exist yet are reported as such.
+// Returns whether the Deployment finished rolling out.
+func (r *VisitorsAppReconciler) updateFrontendStatus(ctx context.Context, v *examplecomv1.VisitorsApp) (bool, error) {
+	rolledOut := false
+	dep := &appsv1.Deployment{}
 	err := r.Get(ctx, types.NamespacedName{
 		Name:      frontendDeploymentName(v),
 		Namespace: v.Namespace,
-	}, foundDeployment)
-	if err != nil {
-		// The deployment may not have been created yet, so requeue
-		return &ctrl.Result{RequeueAfter: 5 * time.Second}, err
+	}, dep)
+	if err != nil && errors.IsNotFound(err) {
+		v.Status.Frontend.Replicas = 0
+		v.Status.Frontend.ReadyReplicas = 0
+		v.Status.Frontend.UpdatedReplicas = 0
+		setCondition(v, examplecomv1.ConditionFrontendAvailable, metav1.ConditionFalse, "DeploymentNotFound",
+			fmt.Sprintf("Deployment %s has not been created yet", frontendDeploymentName(v)))
+	} else if err != nil {
+		return false, err
+	} else {
+		v.Status.Frontend.Image = dep.Spec.Template.Spec.Containers[0].Image
+		v.Status.Frontend.Replicas = *dep.Spec.Replicas
+		v.Status.Frontend.ReadyReplicas = dep.Status.ReadyReplicas
+		v.Status.Frontend.UpdatedReplicas = dep.Status.UpdatedReplicas
+		rolledOut = deploymentRolledOut(dep)
+		setDeploymentCondition(v, examplecomv1.ConditionFrontendAvailable, dep)
 	}
+
+	s := &corev1.Service{}
 	err = r.Get(ctx, types.NamespacedName{
 		Name:      frontendServiceName(v),
 		Namespace: v.Namespace,
-	}, foundService)
-	if err != nil {
-		// The service may not have been created yet, so requeue
-		return &ctrl.Result{RequeueAfter: 5 * time.Second}, err
-	}
-
-	frontendAutoScaling := v.Spec.FrontendAutoScaling
-	frontendTitle := v.Spec.FrontendTitle
-	frontendSize := v.Spec.FrontendSize
-	frontendServiceNodePort := v.Spec.FrontendServiceNodePort
-
-	existingFrontendTitle := (*foundDeployment).Spec.Template.Spec.Containers[0].Env[0].Value
-	existingFrontendSize := *foundDeployment.Spec.Replicas
-	existingFrontendServiceNodePort := (*foundService).Spec.Ports[0].NodePort
-
-	if frontendTitle != existingFrontendTitle {
-		(*foundDeployment).Spec.Template.Spec.Containers[0].Env[0].Value = frontendTitle
-		err = r.Update(ctx, foundDeployment)
-		if err != nil {
-			log.Error(err, "Failed to update Deployment.", "Deployment.Namespace", foundDeployment.Namespace, "Deployment.Name", foundDeployment.Name)
-			return &ctrl.Result{}, err
-		}
-		// Spec updated - return and requeue
-		return &ctrl.Result{Requeue: true}, nil
-	}
-
-	if !frontendAutoScaling {
-		if frontendSize != existingFrontendSize {
-			foundDeployment.Spec.Replicas = &frontendSize
-			err = r.Update(ctx, foundDeployment)
-			if err != nil {
-				log.Error(err, "Failed to update Deployment.", "Deployment.Namespace", foundDeployment.Namespace, "Deployment.Name", foundDeployment.Name)
-				return &ctrl.Result{}, err
-			}
-			// Spec updated - return and requeue
-			return &ctrl.Result{Requeue: true}, nil
-		}
-	}
-
-	if frontendServiceNodePort != existingFrontendServiceNodePort {
-		(*foundService).Spec.Ports[0].NodePort = frontendServiceNodePort
-		err = r.Update(ctx, foundService)
-		if err != nil {
-			log.Error(err, "Failed to update Service.", "Service.Namespace", foundService.Namespace, "Service.Name", foundService.Name)
-			return &ctrl.Result{}, err
-		}
-		// Spec updated - return and requeue
-		return &ctrl.Result{Requeue: true}, nil
+	}, s)
+	if err != nil && errors.IsNotFound(err) {
+		v.Status.Frontend.URL = ""
+	} else if err != nil {
+		return false, err
+	} else {
+		v.Status.Frontend.URL = serviceURL(s)
 	}
 
-	return nil, nil
+	return rolledOut, nil
 }
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func ingressName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-ingress"
+}
+
+func newIngress(v *examplecomv1.VisitorsApp) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingressName(v),
+			Namespace: v.Namespace,
+		},
+	}
+}
+
+// Returns the paths the frontend and the backend are served at
+func ingressPaths(v *examplecomv1.VisitorsApp) (string, string) {
+	path := strings.TrimSuffix(v.Spec.Ingress.Path, "/")
+	if path == "" {
+		return "/", "/api"
+	}
+	return path, path + "/api"
+}
+
+func ingressPath(path string, serviceName string, port int32) networkingv1.HTTPIngressPath {
+	pathType := networkingv1.PathTypePrefix
+	return networkingv1.HTTPIngressPath{
+		Path:     path,
+		PathType: &pathType,
+		Backend: networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: serviceName,
+				Port: networkingv1.ServiceBackendPort{Number: port},
+			},
+		},
+	}
+}
+
+func (r *VisitorsAppReconciler) ingress(v *examplecomv1.VisitorsApp) *networkingv1.Ingress {
+	spec := v.Spec.Ingress
+	frontendPath, backendPath := ingressPaths(v)
+
+	ing := newIngress(v)
+	ing.Labels = labels(v, "ingress")
+	ing.Annotations = spec.Annotations
+	ing.Spec = networkingv1.IngressSpec{
+		IngressClassName: spec.IngressClassName,
+		Rules: []networkingv1.IngressRule{{
+			Host: spec.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						ingressPath(backendPath, backendServiceName(v), backendPort),
+						ingressPath(frontendPath, frontendServiceName(v), frontendPort),
+					},
+				},
+			},
+		}},
+	}
+
+	if spec.TLSSecretName != "" {
+		tls := networkingv1.IngressTLS{SecretName: spec.TLSSecretName}
+		if spec.Host != "" {
+			tls.Hosts = []string{spec.Host}
+		}
+		ing.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+
+	controllerutil.SetControllerReference(v, ing, r.Scheme)
+	return ing
+}
+
+// Applies the Ingress, or deletes it once the section is removed from the spec
+func (r *VisitorsAppReconciler) ensureIngress(ctx context.Context, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	if v.Spec.Ingress == nil {
+		return r.ensureDeleted(ctx, v, newIngress(v))
+	}
+
+	return r.ensureApplied(ctx, v, r.ingress(v), &networkingv1.Ingress{})
+}
+
+// Replaces the Service URLs in the status with the public ones when the
+// Ingress has a host
+func setIngressURLs(v *examplecomv1.VisitorsApp) {
+	if v.Spec.Ingress == nil || v.Spec.Ingress.Host == "" {
+		return
+	}
+
+	scheme := "http"
+	if v.Spec.Ingress.TLSSecretName != "" {
+		scheme = "https"
+	}
+	frontendPath, backendPath := ingressPaths(v)
+
+	v.Status.Frontend.URL = scheme + "://" + v.Spec.Ingress.Host + frontendPath
+	v.Status.Backend.URL = scheme + "://" + v.Spec.Ingress.Host + backendPath
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Ingress", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		className := "nginx"
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "public", Namespace: "default", UID: "public-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Ingress: &examplecomv1.IngressSpec{
+					Host:             "visitors.example.com",
+					IngressClassName: &className,
+					TLSSecretName:    "visitors-tls",
+					Annotations:      map[string]string{"cert-manager.io/cluster-issuer": "letsencrypt"},
+				},
+			},
+		}
+		r = newTestReconciler(app)
+	})
+
+	ensureIngress := func() (*networkingv1.Ingress, error) {
+		result, err := r.ensureIngress(ctx, app)
+		Expect(result).To(BeNil())
+		Expect(err).NotTo(HaveOccurred())
+
+		ing := &networkingv1.Ingress{}
+		err = r.Get(ctx, types.NamespacedName{Name: "public-ingress", Namespace: app.Namespace}, ing)
+		return ing, err
+	}
+
+	paths := func(ing *networkingv1.Ingress) map[string]string {
+		services := map[string]string{}
+		for _, path := range ing.Spec.Rules[0].HTTP.Paths {
+			services[path.Path] = path.Backend.Service.Name
+		}
+		return services
+	}
+
+	It("routes the frontend and the backend Services", func() {
+		ing, err := ensureIngress()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metav1.IsControlledBy(ing, app)).To(BeTrue())
+		Expect(*ing.Spec.IngressClassName).To(Equal("nginx"))
+		Expect(ing.Annotations).To(HaveKeyWithValue("cert-manager.io/cluster-issuer", "letsencrypt"))
+		Expect(ing.Spec.Rules[0].Host).To(Equal("visitors.example.com"))
+		Expect(paths(ing)).To(Equal(map[string]string{
+			"/":    "public-frontend-service",
+			"/api": "public-backend-service",
+		}))
+		Expect(ing.Spec.TLS).To(Equal([]networkingv1.IngressTLS{{
+			Hosts:      []string{"visitors.example.com"},
+			SecretName: "visitors-tls",
+		}}))
+
+		setIngressURLs(app)
+		Expect(app.Status.Frontend.URL).To(Equal("https://visitors.example.com/"))
+		Expect(app.Status.Backend.URL).To(Equal("https://visitors.example.com/api"))
+	})
+
+	It("follows the spec and is deleted once the section is removed", func() {
+		_, err := ensureIngress()
+		Expect(err).NotTo(HaveOccurred())
+
+		app.Spec.Ingress.Path = "/visitors/"
+		ing, err := ensureIngress()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths(ing)).To(Equal(map[string]string{
+			"/visitors":     "public-frontend-service",
+			"/visitors/api": "public-backend-service",
+		}))
+
+		app.Spec.Ingress = nil
+		_, err = ensureIngress()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Phases of a reconcile, in the order they run
+const (
+	phaseDatabase = "database"
+	phaseBackend  = "backend"
+	phaseFrontend = "frontend"
+)
+
+var (
+	replicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "visitorsapp_replicas",
+		Help: "Desired number of pods of a VisitorsApp tier",
+	}, []string{"namespace", "name", "tier"})
+
+	readyReplicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "visitorsapp_ready_replicas",
+		Help: "Number of ready pods of a VisitorsApp tier",
+	}, []string{"namespace", "name", "tier"})
+
+	databaseReadyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "visitorsapp_database_ready",
+		Help: "Whether the MySQL cluster of a VisitorsApp is ready (1) or not (0)",
+	}, []string{"namespace", "name"})
+
+	databaseWaitingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "visitorsapp_database_waiting_seconds",
+		Help: "Time a VisitorsApp has been waiting for its MySQL cluster, 0 once it is ready",
+	}, []string{"namespace", "name"})
+
+	driftCorrectionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "visitorsapp_drift_corrections_total",
+		Help: "Number of fields of owned objects restored after they were changed outside of the VisitorsApp",
+	}, []string{"namespace", "name", "kind", "field"})
+
+	reconcilePhaseCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "visitorsapp_reconcile_phase_total",
+		Help: "Number of reconcile phases by outcome (success, requeue or error)",
+	}, []string{"namespace", "name", "phase", "result"})
+)
+
+// Generation of the VisitorsApp each owned object was last applied for, by
+// VisitorsApp and then by kind and name
+var (
+	appliedGenerationsMu sync.Mutex
+	appliedGenerations   = map[types.NamespacedName]map[string]int64{}
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		replicasGauge,
+		readyReplicasGauge,
+		databaseReadyGauge,
+		databaseWaitingGauge,
+		driftCorrectionsCounter,
+		reconcilePhaseCounter,
+	)
+}
+
+func observeReconcilePhase(v *examplecomv1.VisitorsApp, phase string, result *ctrl.Result, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	} else if result != nil {
+		outcome = "requeue"
+	}
+	reconcilePhaseCounter.WithLabelValues(v.Namespace, v.Name, phase, outcome).Inc()
+}
+
+// Exports the replicas and the database readiness found in the status as of
+// now
+func observeStatus(v *examplecomv1.VisitorsApp, now time.Time) {
+	replicasGauge.WithLabelValues(v.Namespace, v.Name, "backend").Set(float64(v.Status.Backend.Replicas))
+	replicasGauge.WithLabelValues(v.Namespace, v.Name, "frontend").Set(float64(v.Status.Frontend.Replicas))
+	readyReplicasGauge.WithLabelValues(v.Namespace, v.Name, "backend").Set(float64(v.Status.Backend.ReadyReplicas))
+	readyReplicasGauge.WithLabelValues(v.Namespace, v.Name, "frontend").Set(float64(v.Status.Frontend.ReadyReplicas))
+
+	database := meta.FindStatusCondition(v.Status.Conditions, examplecomv1.ConditionDatabaseReady)
+	if database == nil {
+		return
+	}
+	if database.Status == metav1.ConditionTrue {
+		databaseReadyGauge.WithLabelValues(v.Namespace, v.Name).Set(1)
+		databaseWaitingGauge.WithLabelValues(v.Namespace, v.Name).Set(0)
+	} else {
+		databaseReadyGauge.WithLabelValues(v.Namespace, v.Name).Set(0)
+		databaseWaitingGauge.WithLabelValues(v.Namespace, v.Name).Set(now.Sub(database.LastTransitionTime.Time).Seconds())
+	}
+}
+
+// Counts the fields of an owned object that were restored by an apply. Only
+// objects already applied for the current generation of the VisitorsApp are
+// counted, the others carry changes of the spec rather than drift. Tiers are
+// not all applied in the reconcile that observes a generation, so the status
+// cannot tell.
+func observeDriftCorrections(v *examplecomv1.VisitorsApp, kind string, before client.Object, after client.Object) {
+	if v.Generation == 0 || appliedGeneration(v, kind, after) != v.Generation {
+		return
+	}
+
+	fields, err := driftedFields(before, after)
+	if err != nil {
+		return
+	}
+	for _, field := range fields {
+		driftCorrectionsCounter.WithLabelValues(v.Namespace, v.Name, kind, field).Inc()
+	}
+}
+
+// Records that obj was applied for the current generation of v
+func recordApplied(v *examplecomv1.VisitorsApp, kind string, obj client.Object) {
+	appliedGenerationsMu.Lock()
+	defer appliedGenerationsMu.Unlock()
+
+	key := types.NamespacedName{Name: v.Name, Namespace: v.Namespace}
+	if appliedGenerations[key] == nil {
+		appliedGenerations[key] = map[string]int64{}
+	}
+	appliedGenerations[key][kind+"/"+obj.GetName()] = v.Generation
+}
+
+// Forgets that obj was applied, so that the next apply is not counted as drift
+func forgetApplied(v *examplecomv1.VisitorsApp, kind string, obj client.Object) {
+	appliedGenerationsMu.Lock()
+	defer appliedGenerationsMu.Unlock()
+
+	delete(appliedGenerations[types.NamespacedName{Name: v.Name, Namespace: v.Namespace}], kind+"/"+obj.GetName())
+}
+
+// Returns the generation of v obj was last applied for, 0 if it was not
+// applied since the operator started
+func appliedGeneration(v *examplecomv1.VisitorsApp, kind string, obj client.Object) int64 {
+	appliedGenerationsMu.Lock()
+	defer appliedGenerationsMu.Unlock()
+
+	return appliedGenerations[types.NamespacedName{Name: v.Name, Namespace: v.Namespace}][kind+"/"+obj.GetName()]
+}
+
+// Returns the paths of the spec, labels and annotations of after that differ in before
+func driftedFields(before client.Object, after client.Object) ([]string, error) {
+	beforeContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(before)
+	if err != nil {
+		return nil, err
+	}
+	afterContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	fields = append(fields, diffFields("spec", beforeContent["spec"], afterContent["spec"])...)
+	beforeMeta, _ := beforeContent["metadata"].(map[string]interface{})
+	afterMeta, _ := afterContent["metadata"].(map[string]interface{})
+	for _, key := range []string{"labels", "annotations"} {
+		fields = append(fields, diffFields("metadata."+key, beforeMeta[key], afterMeta[key])...)
+	}
+
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// Walks down the maps, and the lists of named items such as containers, of
+// after and returns the paths of the values that differ in before
+func diffFields(path string, before interface{}, after interface{}) []string {
+	switch afterValue := after.(type) {
+	case map[string]interface{}:
+		beforeValue, _ := before.(map[string]interface{})
+		var fields []string
+		for key, value := range afterValue {
+			fields = append(fields, diffFields(path+"."+key, beforeValue[key], value)...)
+		}
+		return fields
+	case []interface{}:
+		if names, ok := itemNames(afterValue); ok {
+			beforeValue, _ := before.([]interface{})
+			beforeItems := map[string]interface{}{}
+			if beforeNames, ok := itemNames(beforeValue); ok {
+				for i, name := range beforeNames {
+					beforeItems[name] = beforeValue[i]
+				}
+			}
+			var fields []string
+			for i, name := range names {
+				fields = append(fields, diffFields(path+"["+name+"]", beforeItems[name], afterValue[i])...)
+			}
+			return fields
+		}
+	}
+
+	if equality.Semantic.DeepEqual(before, after) {
+		return nil
+	}
+	return []string{path}
+}
+
+// Returns the names of the items of a list, if all of them are named
+func itemNames(items []interface{}) ([]string, bool) {
+	names := []string{}
+	for _, item := range items {
+		content, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := content["name"].(string)
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+	}
+	return names, len(names) > 0
+}
+
+// Removes the series of a deleted VisitorsApp
+func deleteMetrics(key types.NamespacedName) {
+	labels := prometheus.Labels{"namespace": key.Namespace, "name": key.Name}
+	for _, vec := range []*prometheus.MetricVec{
+		replicasGauge.MetricVec,
+		readyReplicasGauge.MetricVec,
+		databaseReadyGauge.MetricVec,
+		databaseWaitingGauge.MetricVec,
+		driftCorrectionsCounter.MetricVec,
+		reconcilePhaseCounter.MetricVec,
+	} {
+		deleteMatchingSeries(vec, labels)
+	}
+
+	appliedGenerationsMu.Lock()
+	delete(appliedGenerations, key)
+	appliedGenerationsMu.Unlock()
+}
+
+// Deletes the series of vec that carry the given labels, whatever their other
+// labels are
+func deleteMatchingSeries(vec *prometheus.MetricVec, labels prometheus.Labels) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(ch)
+		close(ch)
+	}()
+
+	var matching []prometheus.Labels
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+
+		seriesLabels := prometheus.Labels{}
+		for _, pair := range m.Label {
+			seriesLabels[pair.GetName()] = pair.GetValue()
+		}
+		if labelsMatch(seriesLabels, labels) {
+			matching = append(matching, seriesLabels)
+		}
+	}
+
+	for _, seriesLabels := range matching {
+		vec.Delete(seriesLabels)
+	}
+}
+
+func labelsMatch(seriesLabels prometheus.Labels, labels prometheus.Labels) bool {
+	for name, value := range labels {
+		if seriesLabels[name] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("VisitorsApp metrics", func() {
+	var (
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "metrics",
+				Namespace:  "default",
+				Generation: 2,
+			},
+			Status: examplecomv1.VisitorsAppStatus{ObservedGeneration: 2},
+		}
+	})
+
+	AfterEach(func() {
+		deleteMetrics(types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+	})
+
+	It("counts reconcile phases by outcome", func() {
+		observeReconcilePhase(app, phaseDatabase, &ctrl.Result{}, nil)
+		observeReconcilePhase(app, phaseDatabase, nil, nil)
+		observeReconcilePhase(app, phaseBackend, &ctrl.Result{}, errors.New("boom"))
+
+		Expect(testutil.ToFloat64(reconcilePhaseCounter.WithLabelValues("default", "metrics", phaseDatabase, "requeue"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reconcilePhaseCounter.WithLabelValues("default", "metrics", phaseDatabase, "success"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reconcilePhaseCounter.WithLabelValues("default", "metrics", phaseBackend, "error"))).To(Equal(1.0))
+	})
+
+	It("counts the drifted fields of an owned object", func() {
+		desired := r.backendDeployment(app)
+		drifted := desired.DeepCopy()
+		drifted.Spec.Replicas = nil
+		drifted.Spec.Template.Spec.Containers[0].Image = "elsewhere/visitors-service"
+
+		fields, err := driftedFields(drifted, desired)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields).To(Equal([]string{
+			"spec.replicas",
+			"spec.template.spec.containers[visitors-service].image",
+		}))
+
+		recordApplied(app, "Deployment", desired)
+		observeDriftCorrections(app, "Deployment", drifted, desired)
+		Expect(testutil.ToFloat64(driftCorrectionsCounter.WithLabelValues("default", "metrics", "Deployment", "spec.replicas"))).To(Equal(1.0))
+	})
+
+	It("does not count changes of the spec as drift", func() {
+		recordApplied(app, "Deployment", r.backendDeployment(app))
+		app.Generation = 3
+		desired := r.backendDeployment(app)
+		drifted := desired.DeepCopy()
+		drifted.Spec.Replicas = nil
+
+		others := testutil.CollectAndCount(driftCorrectionsCounter)
+		observeDriftCorrections(app, "Deployment", drifted, desired)
+		Expect(testutil.CollectAndCount(driftCorrectionsCounter)).To(Equal(others))
+	})
+
+	It("exports how long the database has been waiting for", func() {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		meta.SetStatusCondition(&app.Status.Conditions, metav1.Condition{
+			Type:               examplecomv1.ConditionDatabaseReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "MysqlNotReady",
+			LastTransitionTime: metav1.NewTime(now.Add(-40 * time.Second)),
+		})
+
+		observeStatus(app, now)
+		Expect(testutil.ToFloat64(databaseReadyGauge.WithLabelValues("default", "metrics"))).To(BeZero())
+		Expect(testutil.ToFloat64(databaseWaitingGauge.WithLabelValues("default", "metrics"))).To(Equal(40.0))
+
+		app.Status.Conditions[0].Status = metav1.ConditionTrue
+		observeStatus(app, now)
+		Expect(testutil.ToFloat64(databaseReadyGauge.WithLabelValues("default", "metrics"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(databaseWaitingGauge.WithLabelValues("default", "metrics"))).To(BeZero())
+	})
+
+	It("counts drift of an object applied before the status observed the generation", func() {
+		app.Generation = 3
+		desired := r.backendDeployment(app)
+		drifted := desired.DeepCopy()
+		drifted.Spec.Replicas = nil
+
+		recordApplied(app, "Deployment", desired)
+		observeDriftCorrections(app, "Deployment", drifted, desired)
+		Expect(testutil.ToFloat64(driftCorrectionsCounter.WithLabelValues("default", "metrics", "Deployment", "spec.replicas"))).To(Equal(1.0))
+	})
+
+	It("removes the series of a deleted VisitorsApp", func() {
+		others := testutil.CollectAndCount(readyReplicasGauge)
+
+		app.Status.Backend.ReadyReplicas = 1
+		observeStatus(app, time.Now())
+		Expect(testutil.ToFloat64(readyReplicasGauge.WithLabelValues("default", "metrics", "backend"))).To(Equal(1.0))
+
+		deleteMetrics(types.NamespacedName{Name: app.Name, Namespace: app.Namespace})
+		Expect(testutil.CollectAndCount(readyReplicasGauge)).To(Equal(others))
+	})
+})
@@ -2,52 +2,144 @@ package controllers
 
 import (
 	"context"
+	"time"
 
-	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 
-	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func mysqlAuthName() string {
+func mysqlAuthName(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.SecretName != "" {
+		return v.Spec.Database.SecretName
+	}
+	if v.Spec.Database.Managed {
+		return v.Name + "-db-credentials"
+	}
 	return "my-secret"
 }
 
-func mysqlClusterName() string {
+func mysqlUserKey(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.UserKey != "" {
+		return v.Spec.Database.UserKey
+	}
+	return "USER"
+}
+
+func mysqlPasswordKey(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.PasswordKey != "" {
+		return v.Spec.Database.PasswordKey
+	}
+	return "PASSWORD"
+}
+
+func mysqlRootPasswordKey(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.RootPasswordKey != "" {
+		return v.Spec.Database.RootPasswordKey
+	}
+	return "ROOT_PASSWORD"
+}
+
+// Returns whether the credentials Secret holds the root password, which the
+// Jobs connecting as root would otherwise wait for forever
+func hasMysqlRootPassword(ctx context.Context, c client.Reader, v *examplecomv1.VisitorsApp) (bool, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: mysqlAuthName(v), Namespace: v.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(secret.Data[mysqlRootPasswordKey(v)]) > 0, nil
+}
+
+func mysqlDatabaseName(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.DatabaseName != "" {
+		return v.Spec.Database.DatabaseName
+	}
+	return "visitors_db"
+}
+
+func mysqlClusterName(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.ClusterName != "" {
+		return v.Spec.Database.ClusterName
+	}
+	if v.Spec.Database.Managed {
+		return v.Name + "-db"
+	}
 	return "my-cluster"
 }
 
-func mysqlStatefulSetName() string {
-	return mysqlClusterName() + "-mysql"
+// A managed cluster is owned by the VisitorsApp, so it has to live in the same namespace
+func mysqlNamespace(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.Namespace != "" && !v.Spec.Database.Managed {
+		return v.Spec.Database.Namespace
+	}
+	return v.Namespace
+}
+
+func mysqlStatefulSetName(v *examplecomv1.VisitorsApp) string {
+	return mysqlClusterName(v) + "-mysql"
 }
 
-func mysqlServiceRWName() string {
-	return mysqlStatefulSetName() + "-master"
+func mysqlServiceRWName(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.ServiceRWName != "" {
+		return v.Spec.Database.ServiceRWName
+	}
+	return mysqlStatefulSetName(v) + "-master"
 }
 
-func mysqlServiceROName() string {
-	return mysqlStatefulSetName()
+func mysqlServiceROName(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.ServiceROName != "" {
+		return v.Spec.Database.ServiceROName
+	}
+	return mysqlStatefulSetName(v)
 }
 
-// Returns whether or not the MySQL statefulset is running
-func (r *VisitorsAppReconciler) isMysqlUp(ctx context.Context, v *examplecomv1beta1.VisitorsApp) bool {
-	log := ctrllog.FromContext(ctx)
-	statefulset := &appsv1.StatefulSet{}
+// Returns the host name the backend pods use to reach a MySQL service,
+// qualified with the namespace when the cluster lives in another one
+func mysqlServiceHost(v *examplecomv1.VisitorsApp, service string) string {
+	if mysqlNamespace(v) != v.Namespace {
+		return service + "." + mysqlNamespace(v)
+	}
+	return service
+}
 
-	err := r.Get(ctx, types.NamespacedName{
-		Name:      mysqlStatefulSetName(),
-		Namespace: v.Namespace,
-	}, statefulset)
+// Bounds of the delay before checking a MySQL cluster that is down again
+const (
+	databaseBackoffMin = 5 * time.Second
+	databaseBackoffMax = 5 * time.Minute
+)
 
-	if err != nil {
-		log.Info("StatefulSet mysql not found")
-		return false
+// Returns how long to wait before checking MySQL again. The delay grows with
+// the time MySQL has been down, so it doubles with every check until it
+// reaches databaseBackoffMax.
+func (r *VisitorsAppReconciler) databaseBackoff(v *examplecomv1.VisitorsApp) time.Duration {
+	condition := meta.FindStatusCondition(v.Status.Conditions, examplecomv1.ConditionDatabaseReady)
+	if condition == nil || condition.Status == metav1.ConditionTrue {
+		return databaseBackoffMin
 	}
 
-	if statefulset.Status.ReadyReplicas >= 1 {
-		return true
+	delay := r.clock().Since(condition.LastTransitionTime.Time).Round(time.Second)
+	if delay < databaseBackoffMin {
+		return databaseBackoffMin
+	}
+	if delay > databaseBackoffMax {
+		return databaseBackoffMax
 	}
+	return delay
+}
 
-	return false
+func setDatabaseCondition(v *examplecomv1.VisitorsApp, database DatabaseStatus) {
+	status := metav1.ConditionFalse
+	if database.Ready {
+		status = metav1.ConditionTrue
+	}
+	setCondition(v, examplecomv1.ConditionDatabaseReady, status, database.Reason, database.Message)
 }
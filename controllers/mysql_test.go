@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Database references", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "apps"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{
+					ClusterName:   "orders-cluster",
+					Namespace:     "databases",
+					SecretName:    "orders-credentials",
+					UserKey:       "user",
+					PasswordKey:   "password",
+					ServiceRWName: "orders-rw",
+					ServiceROName: "orders-ro",
+					DatabaseName:  "orders_db",
+				},
+			},
+		}
+	})
+
+	secretKey := func(name string, key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Key:                  key,
+			},
+		}
+	}
+
+	It("connects the backend to the shared cluster by default", func() {
+		Expect(backendEnv(&examplecomv1.VisitorsApp{ObjectMeta: metav1.ObjectMeta{Name: "visitors", Namespace: "apps"}})).To(Equal([]corev1.EnvVar{
+			{Name: "MYSQL_DATABASE", Value: "visitors_db"},
+			{Name: "MYSQL_SERVICE_HOST_RW", Value: "my-cluster-mysql-master"},
+			{Name: "MYSQL_SERVICE_HOST_RO", Value: "my-cluster-mysql"},
+			{Name: "MYSQL_USERNAME", ValueFrom: secretKey("my-secret", "USER")},
+			{Name: "MYSQL_PASSWORD", ValueFrom: secretKey("my-secret", "PASSWORD")},
+		}))
+	})
+
+	It("connects the backend to the referenced cluster", func() {
+		Expect(backendEnv(app)).To(Equal([]corev1.EnvVar{
+			{Name: "MYSQL_DATABASE", Value: "orders_db"},
+			{Name: "MYSQL_SERVICE_HOST_RW", Value: "orders-rw.databases"},
+			{Name: "MYSQL_SERVICE_HOST_RO", Value: "orders-ro.databases"},
+			{Name: "MYSQL_USERNAME", ValueFrom: secretKey("orders-credentials", "user")},
+			{Name: "MYSQL_PASSWORD", ValueFrom: secretKey("orders-credentials", "password")},
+		}))
+	})
+
+	It("checks the StatefulSet of the referenced cluster", func() {
+		statefulset := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders-cluster-mysql", Namespace: "databases"},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+		}
+		// A cluster of the same name in the namespace of the VisitorsApp is not the one referenced
+		local := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders-cluster-mysql", Namespace: "apps"},
+		}
+		checker := &StatefulSetChecker{Reader: newTestReconciler(statefulset, local).Client}
+
+		Expect(checker.CheckDatabase(ctx, app)).To(Equal(DatabaseStatus{
+			Ready:   true,
+			Reason:  "MysqlReady",
+			Message: "StatefulSet databases/orders-cluster-mysql has ready replicas",
+		}))
+	})
+})
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The presslabs MysqlCluster is handled as an unstructured object, so that the
+// operator does not depend on the mysql-operator Go module.
+var mysqlClusterGVK = schema.GroupVersionKind{
+	Group:   "mysql.presslabs.org",
+	Version: "v1alpha1",
+	Kind:    "MysqlCluster",
+}
+
+const mysqlDefaultVersion = "5.7.31"
+const mysqlDefaultUser = "visitors-user"
+
+// Annotation of the managed MysqlCluster recording that the operator set the
+// pod resources, so that they are removed again once left out of the spec
+const mysqlResourcesAnnotation = "example.com.my.domain/pod-resources"
+
+func newMysqlCluster() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(mysqlClusterGVK)
+	return u
+}
+
+func mysqlReplicas(v *examplecomv1.VisitorsApp) int32 {
+	if v.Spec.Database.Size != nil {
+		return *v.Spec.Database.Size
+	}
+	return 1
+}
+
+func mysqlVersion(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.MysqlVersion != "" {
+		return v.Spec.Database.MysqlVersion
+	}
+	return mysqlDefaultVersion
+}
+
+// Renders the spec of the managed MysqlCluster as unstructured content
+func mysqlClusterSpec(v *examplecomv1.VisitorsApp) (map[string]interface{}, error) {
+	spec := map[string]interface{}{
+		"replicas":     int64(mysqlReplicas(v)),
+		"secretName":   mysqlAuthName(v),
+		"mysqlVersion": mysqlVersion(v),
+	}
+
+	resources := v.Spec.Database.Resources
+	if len(resources.Requests) > 0 || len(resources.Limits) > 0 {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resources)
+		if err != nil {
+			return nil, err
+		}
+		spec["podSpec"] = map[string]interface{}{
+			"resources": content,
+		}
+	}
+
+	return spec, nil
+}
+
+func (r *VisitorsAppReconciler) mysqlCluster(v *examplecomv1.VisitorsApp) (*unstructured.Unstructured, error) {
+	spec, err := mysqlClusterSpec(v)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := newMysqlCluster()
+	cluster.SetName(mysqlClusterName(v))
+	cluster.SetNamespace(v.Namespace)
+	cluster.SetLabels(labels(v, "database"))
+	cluster.Object["spec"] = spec
+	if _, ok := spec["podSpec"]; ok {
+		cluster.SetAnnotations(map[string]string{mysqlResourcesAnnotation: "true"})
+	}
+
+	controllerutil.SetControllerReference(v, cluster, r.Scheme)
+	return cluster, nil
+}
+
+// Renders the credentials Secret of the managed MysqlCluster with freshly
+// generated passwords. The presslabs operator reads ROOT_PASSWORD, DATABASE,
+// USER and PASSWORD; the keys configured in the spec are filled in as well.
+func (r *VisitorsAppReconciler) mysqlSecret(v *examplecomv1.VisitorsApp) (*corev1.Secret, error) {
+	rootPassword, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{
+		"ROOT_PASSWORD": []byte(rootPassword),
+		"DATABASE":      []byte(mysqlDatabaseName(v)),
+		"USER":          []byte(mysqlDefaultUser),
+		"PASSWORD":      []byte(password),
+	}
+	data[mysqlUserKey(v)] = []byte(mysqlDefaultUser)
+	data[mysqlPasswordKey(v)] = []byte(password)
+	data[mysqlRootPasswordKey(v)] = []byte(rootPassword)
+
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mysqlAuthName(v),
+			Namespace: v.Namespace,
+			Labels:    labels(v, "database"),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	controllerutil.SetControllerReference(v, s, r.Scheme)
+	return s, nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Creates the credentials Secret and the MysqlCluster of a managed database,
+// and keeps the cluster spec in line with the VisitorsApp.
+// An existing Secret is never overwritten, so the passwords stay stable.
+func (r *VisitorsAppReconciler) ensureMysqlCluster(ctx context.Context, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	foundSecret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      mysqlAuthName(v),
+		Namespace: v.Namespace,
+	}, foundSecret)
+	if err != nil && errors.IsNotFound(err) {
+		s, err := r.mysqlSecret(v)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		log.Info("Creating a new Secret", "Secret.Namespace", s.Namespace, "Secret.Name", s.Name)
+		err = r.Create(ctx, s)
+		if err != nil {
+			log.Error(err, "Failed to create new Secret", "Secret.Namespace", s.Namespace, "Secret.Name", s.Name)
+			return &ctrl.Result{}, err
+		}
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("Secret", s))
+	} else if err != nil {
+		log.Error(err, "Failed to get Secret")
+		return &ctrl.Result{}, err
+	}
+
+	cluster, err := r.mysqlCluster(v)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	found := newMysqlCluster()
+	err = r.Get(ctx, types.NamespacedName{
+		Name:      cluster.GetName(),
+		Namespace: cluster.GetNamespace(),
+	}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new MysqlCluster", "MysqlCluster.Namespace", cluster.GetNamespace(), "MysqlCluster.Name", cluster.GetName())
+		err = r.Create(ctx, cluster)
+		if err != nil {
+			log.Error(err, "Failed to create new MysqlCluster", "MysqlCluster.Namespace", cluster.GetNamespace(), "MysqlCluster.Name", cluster.GetName())
+			return &ctrl.Result{}, err
+		}
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("MysqlCluster", cluster))
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get MysqlCluster")
+		return &ctrl.Result{}, err
+	}
+
+	// Only the fields rendered by the operator are compared, anything else
+	// set on the cluster (e.g. by the presslabs defaults) is left alone
+	changed := false
+	for _, path := range [][]string{
+		{"spec", "replicas"},
+		{"spec", "secretName"},
+		{"spec", "mysqlVersion"},
+		{"spec", "podSpec", "resources"},
+	} {
+		desired, ok, _ := unstructured.NestedFieldNoCopy(cluster.Object, path...)
+		if !ok {
+			continue
+		}
+		existing, _, _ := unstructured.NestedFieldNoCopy(found.Object, path...)
+		if !equality.Semantic.DeepEqual(desired, existing) {
+			err = unstructured.SetNestedField(found.Object, runtime.DeepCopyJSONValue(desired), path...)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+			changed = true
+		}
+	}
+
+	// Resources the presslabs defaults filled in are not the operator's to
+	// remove, only the ones it set itself
+	_, rendered := cluster.GetAnnotations()[mysqlResourcesAnnotation]
+	annotations := found.GetAnnotations()
+	if _, set := annotations[mysqlResourcesAnnotation]; set != rendered {
+		if rendered {
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[mysqlResourcesAnnotation] = "true"
+		} else {
+			unstructured.RemoveNestedField(found.Object, "spec", "podSpec", "resources")
+			delete(annotations, mysqlResourcesAnnotation)
+		}
+		found.SetAnnotations(annotations)
+		changed = true
+	}
+
+	if changed {
+		err = r.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "Failed to update MysqlCluster.", "MysqlCluster.Namespace", found.GetNamespace(), "MysqlCluster.Name", found.GetName())
+			return &ctrl.Result{}, err
+		}
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonUpdated, "Updated "+describe("MysqlCluster", found)+" to match the VisitorsApp")
+		// Spec updated - return and requeue
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Managed MysqlCluster", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		size := int32(2)
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "managed", Namespace: "default", UID: "managed-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{
+					Managed:      true,
+					Size:         &size,
+					MysqlVersion: "8.0.20",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+					},
+					UserKey:     "username",
+					PasswordKey: "password",
+				},
+			},
+		}
+		r = newTestReconciler(app)
+	})
+
+	ensureCluster := func() *unstructured.Unstructured {
+		_, err := r.ensureMysqlCluster(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+
+		cluster := newMysqlCluster()
+		Expect(r.Get(ctx, types.NamespacedName{Name: "managed-db", Namespace: app.Namespace}, cluster)).To(Succeed())
+		return cluster
+	}
+
+	secret := func() *corev1.Secret {
+		s := &corev1.Secret{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "managed-db-credentials", Namespace: app.Namespace}, s)).To(Succeed())
+		return s
+	}
+
+	It("creates the cluster and its credentials from the spec", func() {
+		cluster := ensureCluster()
+		Expect(metav1.IsControlledBy(cluster, app)).To(BeTrue())
+		Expect(cluster.Object["spec"]).To(Equal(map[string]interface{}{
+			"replicas":     int64(2),
+			"secretName":   "managed-db-credentials",
+			"mysqlVersion": "8.0.20",
+			"podSpec": map[string]interface{}{
+				"resources": map[string]interface{}{
+					"requests": map[string]interface{}{"memory": "1Gi"},
+				},
+			},
+		}))
+
+		s := secret()
+		Expect(metav1.IsControlledBy(s, app)).To(BeTrue())
+		Expect(s.Data).To(HaveKey("ROOT_PASSWORD"))
+		Expect(string(s.Data["DATABASE"])).To(Equal("visitors_db"))
+		Expect(string(s.Data["username"])).To(Equal(mysqlDefaultUser))
+		Expect(s.Data["password"]).To(Equal(s.Data["PASSWORD"]))
+	})
+
+	It("updates the fields it renders and keeps the passwords", func() {
+		ensureCluster()
+		passwords := secret().Data
+
+		// Set by the presslabs defaults
+		cluster := ensureCluster()
+		Expect(unstructured.SetNestedField(cluster.Object, "0 0 0 * * *", "spec", "backupSchedule")).To(Succeed())
+		Expect(r.Update(ctx, cluster)).To(Succeed())
+
+		size := int32(3)
+		app.Spec.Database.Size = &size
+		result, err := r.ensureMysqlCluster(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).NotTo(BeNil())
+
+		cluster = newMysqlCluster()
+		Expect(r.Get(ctx, types.NamespacedName{Name: "managed-db", Namespace: app.Namespace}, cluster)).To(Succeed())
+		replicas, _, _ := unstructured.NestedInt64(cluster.Object, "spec", "replicas")
+		Expect(replicas).To(Equal(int64(3)))
+		schedule, _, _ := unstructured.NestedString(cluster.Object, "spec", "backupSchedule")
+		Expect(schedule).To(Equal("0 0 0 * * *"))
+		Expect(secret().Data).To(Equal(passwords))
+	})
+	It("removes the resources it set once they are left out of the spec", func() {
+		Expect(ensureCluster().GetAnnotations()).To(HaveKey(mysqlResourcesAnnotation))
+
+		app.Spec.Database.Resources = corev1.ResourceRequirements{}
+		cluster := ensureCluster()
+		_, found, _ := unstructured.NestedFieldNoCopy(cluster.Object, "spec", "podSpec", "resources")
+		Expect(found).To(BeFalse())
+		Expect(cluster.GetAnnotations()).NotTo(HaveKey(mysqlResourcesAnnotation))
+
+		// Set by the presslabs defaults
+		Expect(unstructured.SetNestedField(cluster.Object, map[string]interface{}{
+			"requests": map[string]interface{}{"memory": "1Gi"},
+		}, "spec", "podSpec", "resources")).To(Succeed())
+		Expect(r.Update(ctx, cluster)).To(Succeed())
+		_, found, _ = unstructured.NestedFieldNoCopy(ensureCluster().Object, "spec", "podSpec", "resources")
+		Expect(found).To(BeTrue())
+	})
+})
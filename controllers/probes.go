@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Path of the backend endpoint that lists the visits, it only answers once
+// the connection to MySQL is established
+const backendReadinessPath = "/visitors/"
+
+// The startup probe gives a container up to 150s to come up before the
+// liveness probe takes over
+func startupProbe(handler corev1.ProbeHandler) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler:     handler,
+		PeriodSeconds:    5,
+		FailureThreshold: 30,
+	}
+}
+
+func livenessProbe(handler corev1.ProbeHandler) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler:     handler,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+}
+
+func readinessProbe(handler corev1.ProbeHandler) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler:     handler,
+		PeriodSeconds:    5,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+}
+
+func tcpProbeHandler(port int) corev1.ProbeHandler {
+	return corev1.ProbeHandler{
+		TCPSocket: &corev1.TCPSocketAction{
+			Port: intstr.FromInt(port),
+		},
+	}
+}
+
+func httpProbeHandler(path string, port int) corev1.ProbeHandler {
+	return corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path: path,
+			Port: intstr.FromInt(port),
+		},
+	}
+}
+
+// Returns the probe set in the spec, or the default one
+func probe(spec *corev1.Probe, defaultProbe *corev1.Probe) *corev1.Probe {
+	if spec != nil {
+		return spec
+	}
+	return defaultProbe
+}
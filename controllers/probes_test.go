@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var _ = Describe("Container probes", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "probed", Namespace: "default", UID: "probed-uid"},
+		}
+		r = newTestReconciler(app)
+	})
+
+	It("probes the ports of the containers by default", func() {
+		backend := r.backendDeployment(app).Spec.Template.Spec.Containers[0]
+		Expect(backend.StartupProbe.TCPSocket.Port).To(Equal(intstr.FromInt(backendPort)))
+		Expect(backend.LivenessProbe.TCPSocket.Port).To(Equal(intstr.FromInt(backendPort)))
+		Expect(backend.ReadinessProbe.HTTPGet).To(Equal(&corev1.HTTPGetAction{Path: "/visitors/", Port: intstr.FromInt(backendPort)}))
+
+		frontend := r.frontendDeployment(app).Spec.Template.Spec.Containers[0]
+		for _, p := range []*corev1.Probe{frontend.StartupProbe, frontend.LivenessProbe, frontend.ReadinessProbe} {
+			Expect(p.HTTPGet).To(Equal(&corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt(frontendPort)}))
+		}
+	})
+
+	It("rolls the Deployment to the probes of the spec", func() {
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Name: app.Name, Namespace: app.Namespace}}
+		_, err := r.ensureDeployment(ctx, req, app, r.frontendDeployment(app))
+		Expect(err).NotTo(HaveOccurred())
+
+		override := &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(frontendPort)},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       15,
+		}
+		app.Spec.Frontend.ReadinessProbe = override
+		_, err = r.ensureDeployment(ctx, req, app, r.frontendDeployment(app))
+		Expect(err).NotTo(HaveOccurred())
+
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: frontendDeploymentName(app), Namespace: app.Namespace}, dep)).To(Succeed())
+		container := dep.Spec.Template.Spec.Containers[0]
+		Expect(container.ReadinessProbe).To(Equal(override))
+		// The probes left out of the spec keep their defaults
+		Expect(container.LivenessProbe).To(Equal(livenessProbe(httpProbeHandler("/", frontendPort))))
+	})
+})
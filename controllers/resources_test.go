@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Tier resources", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "resources", Namespace: "default", UID: "resources-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("250m"),
+							corev1.ResourceMemory: resource.MustParse("128Mi"),
+						},
+						Limits: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("250m"),
+							corev1.ResourceMemory: resource.MustParse("128Mi"),
+						},
+					},
+				}},
+			},
+		}
+		r = newTestReconciler(app)
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady"}
+	})
+
+	container := func(name string) corev1.Container {
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, dep)).To(Succeed())
+		return dep.Spec.Template.Spec.Containers[0]
+	}
+
+	It("passes the resources of the spec to the container", func() {
+		reconcileApp(ctx, r, app)
+
+		Expect(container(backendDeploymentName(app)).Resources).To(Equal(app.Spec.Backend.Resources))
+	})
+
+	It("only requests CPU by default, which gives the pods the Burstable QoS class", func() {
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+
+		resources := container(frontendDeploymentName(app)).Resources
+		Expect(resources.Requests).To(HaveLen(1))
+		requests := resources.Requests
+		Expect(requests.Cpu().String()).To(Equal(examplecomv1.DefaultFrontendCPURequest))
+		Expect(resources.Limits).To(BeEmpty())
+	})
+
+	It("rolls the Deployment when the resources change", func() {
+		v, _ := reconcileApp(ctx, r, app)
+
+		v.Spec.Backend.Resources.Limits[corev1.ResourceMemory] = resource.MustParse("256Mi")
+		Expect(r.Update(ctx, v)).To(Succeed())
+		reconcileApp(ctx, r, app)
+
+		limits := container(backendDeploymentName(app)).Resources.Limits
+		Expect(limits.Memory().String()).To(Equal("256Mi"))
+	})
+})
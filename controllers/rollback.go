@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Key of the known-good ConfigMap of a tier holding its pod template
+const knownGoodTemplateKey = "template"
+
+// Waiting reasons of a container that will not start without a new release
+var failedContainerReasons = map[string]bool{
+	"CrashLoopBackOff": true,
+	"ImagePullBackOff": true,
+	"ErrImagePull":     true,
+	"InvalidImageName": true,
+}
+
+func knownGoodConfigMapName(v *examplecomv1.VisitorsApp, tier string) string {
+	return v.Name + "-" + tier + "-known-good"
+}
+
+func tierSpec(v *examplecomv1.VisitorsApp, tier string) *examplecomv1.TierSpec {
+	if tier == "backend" {
+		return &v.Spec.Backend.TierSpec
+	}
+	return &v.Spec.Frontend.TierSpec
+}
+
+func tierStatus(v *examplecomv1.VisitorsApp, tier string) *examplecomv1.TierStatus {
+	if tier == "backend" {
+		return &v.Status.Backend
+	}
+	return &v.Status.Frontend
+}
+
+// Returns whether the tier is kept on its previous release, after the
+// release with the given pod template failed to roll out
+func rolledBack(v *examplecomv1.VisitorsApp, tier string, hash string) bool {
+	status := tierStatus(v, tier)
+	return status.RolledBack != nil && status.RolledBack.TemplateHash == hash
+}
+
+// Sets the pod template of a Deployment, along with the hash identifying it on
+// the Deployment and on its pods
+func setTemplate(dep *appsv1.Deployment, template *corev1.PodTemplateSpec, hash string) {
+	dep.Spec.Template = *template.DeepCopy()
+	if dep.Annotations == nil {
+		dep.Annotations = map[string]string{}
+	}
+	dep.Annotations[templateHashAnnotation] = hash
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = map[string]string{}
+	}
+	dep.Spec.Template.Annotations[templateHashAnnotation] = hash
+}
+
+// Applies the Deployment of a tier. A release whose pods exceed the progress
+// deadline or crash loop is rolled back to the last pod template that rolled
+// out, and the tier is kept on it until its pod template changes again.
+func (r *VisitorsAppReconciler) ensureTierDeployment(ctx context.Context,
+	req ctrl.Request,
+	v *examplecomv1.VisitorsApp,
+	tier string,
+	dep *appsv1.Deployment,
+) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	status := tierStatus(v, tier)
+	autoRollback := !tierSpec(v, tier).DisableAutoRollback
+
+	rendered := dep.Spec.Template.DeepCopy()
+	hash := templateHash(dep)
+
+	knownGood, knownGoodHash, err := r.knownGoodTemplate(ctx, v, tier)
+	if err != nil {
+		log.Error(err, "Failed to read the known-good pod template", "Tier", tier)
+		return &ctrl.Result{}, err
+	}
+
+	// A new release is tried, and so is the failed one once rollbacks are off
+	if status.RolledBack != nil && (status.RolledBack.TemplateHash != hash || !autoRollback) {
+		status.RolledBack = nil
+	}
+	if status.RolledBack != nil && knownGood != nil {
+		setTemplate(dep, knownGood, knownGoodHash)
+	} else {
+		setTemplate(dep, rendered, hash)
+	}
+	desired := dep.DeepCopy()
+
+	result, err := r.ensureDeployment(ctx, req, v, dep)
+	if result != nil {
+		return result, err
+	}
+
+	applied := desired.Annotations[templateHashAnnotation]
+	if deploymentRolledOut(dep) {
+		if applied == knownGoodHash {
+			return nil, nil
+		}
+		return r.ensureKnownGoodTemplate(ctx, v, tier, &desired.Spec.Template, applied)
+	}
+	if !autoRollback || knownGood == nil || applied == knownGoodHash {
+		return nil, nil
+	}
+
+	failure, err := r.rolloutFailure(ctx, v, tier, dep, applied)
+	if err != nil {
+		log.Error(err, "Failed to list the pods", "Tier", tier)
+		return &ctrl.Result{}, err
+	}
+	if failure == "" {
+		return nil, nil
+	}
+
+	log.Info("Rolling back a failed release", "Deployment.Name", dep.Name, "Reason", failure)
+	status.RolledBack = &examplecomv1.RollbackStatus{
+		TemplateHash: hash,
+		Message:      failure,
+		Time:         metav1.Time{Time: r.clock().Now()},
+	}
+	r.Recorder.Eventf(v, corev1.EventTypeWarning, eventReasonRolledBack,
+		"Rolled back %s to its previous release: %s", describe("Deployment", dep), failure)
+
+	// The rollback restores fields of the generation just applied, which is
+	// not drift
+	forgetApplied(v, "Deployment", dep)
+	setTemplate(desired, knownGood, knownGoodHash)
+	result, err = r.ensureDeployment(ctx, req, v, desired)
+	if result != nil {
+		return result, err
+	}
+	*dep = *desired
+	return nil, nil
+}
+
+// Returns why the pods of the release with the given pod template hash will
+// not become available, or an empty string while they may still do
+func (r *VisitorsAppReconciler) rolloutFailure(ctx context.Context, v *examplecomv1.VisitorsApp, tier string, dep *appsv1.Deployment, hash string) (string, error) {
+	// The conditions of a Deployment that did not observe its current
+	// generation yet are left from its previous rollout
+	if dep.Status.ObservedGeneration >= dep.Generation && deploymentProgressDeadlineExceeded(dep) {
+		return fmt.Sprintf("Deployment %s exceeded its progress deadline", dep.Name), nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(v.Namespace), client.MatchingLabels(labels(v, tier)))
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if pod.Annotations[templateHashAnnotation] != hash {
+			continue
+		}
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, c := range statuses {
+			if c.State.Waiting != nil && failedContainerReasons[c.State.Waiting.Reason] {
+				return fmt.Sprintf("container %s of pod %s is in %s", c.Name, pod.Name, c.State.Waiting.Reason), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Returns the last pod template of a tier that rolled out and its hash, or nil
+// when none was recorded yet
+func (r *VisitorsAppReconciler) knownGoodTemplate(ctx context.Context, v *examplecomv1.VisitorsApp, tier string) (*corev1.PodTemplateSpec, string, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      knownGoodConfigMapName(v, tier),
+		Namespace: v.Namespace,
+	}, cm)
+	if errors.IsNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	template := &corev1.PodTemplateSpec{}
+	err = json.Unmarshal([]byte(cm.Data[knownGoodTemplateKey]), template)
+	if err != nil {
+		// Edited by hand, it is replaced on the next release that rolls out
+		return nil, "", nil
+	}
+	return template, cm.Annotations[templateHashAnnotation], nil
+}
+
+// Records the pod template a tier rolled out as the one to roll back to
+func (r *VisitorsAppReconciler) ensureKnownGoodTemplate(ctx context.Context, v *examplecomv1.VisitorsApp, tier string, template *corev1.PodTemplateSpec, hash string) (*ctrl.Result, error) {
+	cm, err := r.knownGoodConfigMap(v, tier, template, hash)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
+	return r.ensureApplied(ctx, v, cm, &corev1.ConfigMap{})
+}
+
+func (r *VisitorsAppReconciler) knownGoodConfigMap(v *examplecomv1.VisitorsApp, tier string, template *corev1.PodTemplateSpec, hash string) (*corev1.ConfigMap, error) {
+	// The hash on the pods is not part of the template it identifies
+	template = template.DeepCopy()
+	delete(template.Annotations, templateHashAnnotation)
+	if len(template.Annotations) == 0 {
+		template.Annotations = nil
+	}
+
+	content, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        knownGoodConfigMapName(v, tier),
+			Namespace:   v.Namespace,
+			Labels:      labels(v, tier),
+			Annotations: map[string]string{templateHashAnnotation: hash},
+		},
+		Data: map[string]string{knownGoodTemplateKey: string(content)},
+	}
+
+	controllerutil.SetControllerReference(v, cm, r.Scheme)
+	return cm, nil
+}
+
+// Degraded is True while a tier runs a previous release
+func setDegradedCondition(v *examplecomv1.VisitorsApp) {
+	for _, tier := range []string{"backend", "frontend"} {
+		status := tierStatus(v, tier)
+		if status.RolledBack != nil {
+			setCondition(v, examplecomv1.ConditionDegraded, metav1.ConditionTrue, "RolledBack",
+				fmt.Sprintf("The %s was rolled back to its previous release: %s", tier, status.RolledBack.Message))
+			return
+		}
+	}
+
+	setCondition(v, examplecomv1.ConditionDegraded, metav1.ConditionFalse, "AsExpected",
+		"Every tier runs its current release")
+}
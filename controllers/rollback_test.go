@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Automatic rollbacks", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors", Namespace: "default", UID: "visitors-uid"},
+		}
+		r = newTestReconciler()
+	})
+
+	pod := func(name string, hash string, reason string) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Labels:      labels(app, "frontend"),
+				Annotations: map[string]string{templateHashAnnotation: hash},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "visitors-webui"}},
+			},
+		}
+		if reason != "" {
+			p.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: reason}
+		}
+		return p
+	}
+
+	It("detects the pods of a release that will not start", func() {
+		dep := r.frontendDeployment(app)
+
+		// A pod of the previous release crash looping is not the release's fault
+		Expect(r.Create(ctx, pod("old", "previous", "CrashLoopBackOff"))).To(Succeed())
+		Expect(r.Create(ctx, pod("new-1", "current", ""))).To(Succeed())
+		failure, err := r.rolloutFailure(ctx, app, "frontend", dep, "current")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(failure).To(BeEmpty())
+
+		Expect(r.Create(ctx, pod("new-2", "current", "ImagePullBackOff"))).To(Succeed())
+		failure, err = r.rolloutFailure(ctx, app, "frontend", dep, "current")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(failure).To(Equal("container visitors-webui of pod new-2 is in ImagePullBackOff"))
+	})
+
+	It("only trusts the progress deadline of the current generation", func() {
+		dep := r.frontendDeployment(app)
+		dep.Generation = 2
+		dep.Status.ObservedGeneration = 1
+		dep.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentProgressing,
+			Status: corev1.ConditionFalse,
+			Reason: "ProgressDeadlineExceeded",
+		}}
+		failure, err := r.rolloutFailure(ctx, app, "frontend", dep, "current")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(failure).To(BeEmpty())
+
+		dep.Status.ObservedGeneration = 2
+		failure, err = r.rolloutFailure(ctx, app, "frontend", dep, "current")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(failure).To(Equal("Deployment visitors-frontend exceeded its progress deadline"))
+	})
+
+	It("keeps the template that rolled out to roll back to", func() {
+		dep := r.frontendDeployment(app)
+		rendered := dep.Spec.Template.DeepCopy()
+		hash := templateHash(dep)
+		setTemplate(dep, rendered, hash)
+
+		cm, err := r.knownGoodConfigMap(app, "frontend", &dep.Spec.Template, hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Create(ctx, cm)).To(Succeed())
+
+		template, knownGoodHash, err := r.knownGoodTemplate(ctx, app, "frontend")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(knownGoodHash).To(Equal(hash))
+		Expect(template).To(Equal(rendered))
+
+		app.Spec.Frontend.Image.Tag = "broken"
+		failed := r.frontendDeployment(app)
+		setTemplate(failed, template, knownGoodHash)
+		Expect(failed.Spec.Template.Spec.Containers[0].Image).To(Equal(rendered.Spec.Containers[0].Image))
+		Expect(failed.Spec.Template.Annotations).To(HaveKeyWithValue(templateHashAnnotation, hash))
+	})
+
+	It("reports a rolled back tier as Degraded", func() {
+		setDegradedCondition(app)
+		Expect(meta.IsStatusConditionFalse(app.Status.Conditions, examplecomv1.ConditionDegraded)).To(BeTrue())
+
+		app.Status.Frontend.RolledBack = &examplecomv1.RollbackStatus{
+			TemplateHash: "current",
+			Message:      "Deployment visitors-frontend exceeded its progress deadline",
+		}
+		Expect(rolledBack(app, "frontend", "current")).To(BeTrue())
+		Expect(rolledBack(app, "frontend", "next")).To(BeFalse())
+
+		setDegradedCondition(app)
+		degraded := meta.FindStatusCondition(app.Status.Conditions, examplecomv1.ConditionDegraded)
+		Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+		Expect(degraded.Message).To(Equal("The frontend was rolled back to its previous release: " +
+			"Deployment visitors-frontend exceeded its progress deadline"))
+	})
+})
+
+var _ = Describe("Automatic rollback reconciles", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		r        *VisitorsAppReconciler
+		recorder *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "rollback", Namespace: "default", UID: "rollback-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{
+					Image: examplecomv1.ImageSpec{Repository: "registry.example.com/visitors-service", Tag: "1.0.0"},
+				}},
+			},
+		}
+		r = newTestReconciler(app)
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady"}
+		recorder = r.Recorder.(*record.FakeRecorder)
+	})
+
+	events := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	backend := func() *appsv1.Deployment {
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: backendDeploymentName(app), Namespace: app.Namespace}, dep)).To(Succeed())
+		return dep
+	}
+
+	image := func(dep *appsv1.Deployment) string {
+		return dep.Spec.Template.Spec.Containers[0].Image
+	}
+
+	// Runs 1.0.0 on both tiers, then releases a backend image that crash loops
+	releaseBroken := func() {
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, frontendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		events()
+
+		v := &examplecomv1.VisitorsApp{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, v)).To(Succeed())
+		v.Spec.Backend.Image.Tag = "1.1.0-broken"
+		Expect(r.Update(ctx, v)).To(Succeed())
+		reconcileApp(ctx, r, app)
+
+		dep := backend()
+		Expect(image(dep)).To(Equal("registry.example.com/visitors-service:1.1.0-broken"))
+		Expect(r.Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "rollback-backend-broken",
+				Namespace:   app.Namespace,
+				Labels:      labels(app, "backend"),
+				Annotations: dep.Spec.Template.Annotations,
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "visitors-service",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}},
+			},
+		})).To(Succeed())
+	}
+
+	It("rolls a crash looping release back to the previous one", func() {
+		releaseBroken()
+
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(image(backend())).To(Equal("registry.example.com/visitors-service:1.0.0"))
+		Expect(events()).To(ContainElement("Warning RolledBack Rolled back Deployment rollback-backend to its previous release: " +
+			"container visitors-service of pod rollback-backend-broken is in CrashLoopBackOff"))
+		Expect(v.Status.Backend.RolledBack).NotTo(BeNil())
+		degraded := meta.FindStatusCondition(v.Status.Conditions, examplecomv1.ConditionDegraded)
+		Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+		Expect(degraded.Reason).To(Equal("RolledBack"))
+
+		// The failed release is not tried again until the spec changes
+		reconcileApp(ctx, r, app)
+		Expect(image(backend())).To(Equal("registry.example.com/visitors-service:1.0.0"))
+	})
+
+	It("leaves a failed release alone with automatic rollbacks disabled", func() {
+		app.Spec.Backend.DisableAutoRollback = true
+		Expect(r.Update(ctx, app)).To(Succeed())
+		releaseBroken()
+
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(image(backend())).To(Equal("registry.example.com/visitors-service:1.1.0-broken"))
+		Expect(events()).NotTo(ContainElement(HavePrefix("Warning RolledBack")))
+		Expect(v.Status.Backend.RolledBack).To(BeNil())
+		Expect(meta.IsStatusConditionFalse(v.Status.Conditions, examplecomv1.ConditionDegraded)).To(BeTrue())
+	})
+})
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Returns whether a Deployment finished rolling out its current spec, as
+// `kubectl rollout status` does: every pod runs the current template and is
+// available, and no pod of an older template is left.
+func deploymentRolledOut(dep *appsv1.Deployment) bool {
+	if dep.Status.ObservedGeneration < dep.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	if dep.Status.UpdatedReplicas < replicas ||
+		dep.Status.Replicas > dep.Status.UpdatedReplicas ||
+		dep.Status.AvailableReplicas < dep.Status.UpdatedReplicas {
+		return false
+	}
+
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Sets the phase from the readiness of the database and the rollout of each
+// tier, in the order they are reconciled
+func setPhase(v *examplecomv1.VisitorsApp, backendRolledOut bool, frontendRolledOut bool) {
+	databaseReady := meta.IsStatusConditionTrue(v.Status.Conditions, examplecomv1.ConditionDatabaseReady)
+	if v.Spec.Database.Schema != nil {
+		databaseReady = databaseReady && meta.IsStatusConditionTrue(v.Status.Conditions, examplecomv1.ConditionSchemaReady)
+	}
+
+	switch {
+	case !databaseReady:
+		v.Status.Phase = examplecomv1.VisitorsAppPhaseWaitingForDatabase
+	case !backendRolledOut:
+		v.Status.Phase = examplecomv1.VisitorsAppPhaseRollingOutBackend
+	case !frontendRolledOut:
+		v.Status.Phase = examplecomv1.VisitorsAppPhaseRollingOutFrontend
+	default:
+		v.Status.Phase = examplecomv1.VisitorsAppPhaseRunning
+	}
+}
+
+// Annotation of the backend Deployments holding a hash of the pod template
+// they were rendered with, which tells whether a rollout is due
+const templateHashAnnotation = "example.com.my.domain/template-hash"
+
+// Label telling the pods of a Canary Deployment from the stable ones, which
+// share the labels the backend Service selects
+const rolloutTrackLabel = "example.com.my.domain/track"
+
+func backendCanaryDeploymentName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-backend-canary"
+}
+
+func backendPreviewDeploymentName(v *examplecomv1.VisitorsApp) string {
+	return v.Name + "-backend-preview"
+}
+
+// Returns a hash of the pod template of a rendered Deployment
+func templateHash(dep *appsv1.Deployment) string {
+	data, _ := json.Marshal(dep.Spec.Template)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+func rolloutInProgress(v *examplecomv1.VisitorsApp) bool {
+	if v.Status.BackendRollout == nil {
+		return false
+	}
+	switch v.Status.BackendRollout.Phase {
+	case examplecomv1.RolloutPhaseProgressing, examplecomv1.RolloutPhasePaused, examplecomv1.RolloutPhasePromoting:
+		return true
+	}
+	return false
+}
+
+// The pods of a Canary Deployment carry the labels the backend Service
+// selects, so that they get their share of the traffic. They also match the
+// selector of the stable Deployment, which leaves them alone as they are
+// controlled by another Deployment, but not the autoscaler of the backend,
+// which is why Canary rollouts are rejected for an auto-scaled backend. The
+// pods of a BlueGreen preview get no traffic until they are promoted.
+func backendCandidateLabels(v *examplecomv1.VisitorsApp, strategy examplecomv1.RolloutStrategyType) map[string]string {
+	if strategy == examplecomv1.RolloutStrategyBlueGreen {
+		return labels(v, "backend-preview")
+	}
+	labels := labels(v, "backend")
+	labels[rolloutTrackLabel] = "canary"
+	return labels
+}
+
+func backendCandidateDeploymentName(v *examplecomv1.VisitorsApp, strategy examplecomv1.RolloutStrategyType) string {
+	if strategy == examplecomv1.RolloutStrategyBlueGreen {
+		return backendPreviewDeploymentName(v)
+	}
+	return backendCanaryDeploymentName(v)
+}
+
+// Renders the Deployment running the new pods of a rollout next to the stable
+// backend Deployment dep
+func backendCandidateDeployment(v *examplecomv1.VisitorsApp, dep *appsv1.Deployment, strategy examplecomv1.RolloutStrategyType, replicas int32) *appsv1.Deployment {
+	labels := backendCandidateLabels(v, strategy)
+
+	candidate := dep.DeepCopy()
+	candidate.Name = backendCandidateDeploymentName(v, strategy)
+	candidate.Labels = labels
+	candidate.Spec.Replicas = &replicas
+	candidate.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	candidate.Spec.Template.Labels = labels
+	return candidate
+}
+
+// Returns the number of pods the backend runs outside of a rollout. The
+// autoscaler keeps the replicas of an auto-scaled backend.
+func backendTotalReplicas(v *examplecomv1.VisitorsApp, stable *appsv1.Deployment) int32 {
+	if backendAutoScaling(v) && stable.Spec.Replicas != nil {
+		return *stable.Spec.Replicas
+	}
+	return backendSize(v)
+}
+
+// Returns the number of candidate pods running weight percent of the backend,
+// at least one
+func candidateReplicas(total int32, weight int32) int32 {
+	replicas := (total*weight + 99) / 100
+	if replicas < 1 {
+		return 1
+	}
+	return replicas
+}
+
+// Applies the backend Deployment dep. Changes to its pod template go through a
+// candidate Deployment first when the rollout strategy is Canary or
+// BlueGreen, and only reach dep once the candidate pods held each step.
+//
+// Returns the backend Deployment as it runs, and a result to stop the
+// reconcile with while a rollout is in progress.
+func (r *VisitorsAppReconciler) ensureBackendRollout(ctx context.Context, req ctrl.Request, v *examplecomv1.VisitorsApp, dep *appsv1.Deployment) (*appsv1.Deployment, *ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	hash := templateHash(dep)
+	dep.Annotations = map[string]string{templateHashAnnotation: hash}
+	strategy := v.Spec.Backend.RolloutStrategy.Type
+	status := v.Status.BackendRollout
+
+	stable := &appsv1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}, stable)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Deployment")
+		return nil, &ctrl.Result{}, err
+	}
+	exists := err == nil
+
+	promoting := rolloutInProgress(v) && status.Phase == examplecomv1.RolloutPhasePromoting &&
+		status.TemplateHash == hash && status.Strategy == strategy
+	// A release that was rolled back is not rolled out again
+	rollout := exists && stable.Annotations[templateHashAnnotation] != "" && stable.Annotations[templateHashAnnotation] != hash &&
+		(strategy == examplecomv1.RolloutStrategyCanary || strategy == examplecomv1.RolloutStrategyBlueGreen) &&
+		!rolledBack(v, "backend", hash)
+
+	if promoting || !rollout {
+		if rolloutInProgress(v) && !promoting {
+			r.abortBackendRollout(v, "Superseded by a change of the backend")
+		}
+
+		result, err := r.ensureTierDeployment(ctx, req, v, "backend", dep)
+		if result != nil {
+			return nil, result, err
+		}
+		if promoting {
+			if rolledBack(v, "backend", hash) {
+				r.abortBackendRollout(v, fmt.Sprintf("Deployment %s failed to roll out the new version", dep.Name))
+				return dep, nil, nil
+			}
+			if !deploymentRolledOut(dep) {
+				return dep, &ctrl.Result{}, nil
+			}
+			status.Phase = examplecomv1.RolloutPhaseCompleted
+			status.Message = fmt.Sprintf("Deployment %s runs the new version", dep.Name)
+			r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonRolloutCompleted, "Promoted the %s rollout of %s", strategy, describe("Deployment", dep))
+		}
+		return dep, nil, nil
+	}
+
+	if status == nil || status.TemplateHash != hash || status.Strategy != strategy || status.Phase == examplecomv1.RolloutPhaseCompleted {
+		status = &examplecomv1.RolloutStatus{
+			Strategy:     strategy,
+			Phase:        examplecomv1.RolloutPhaseProgressing,
+			TemplateHash: hash,
+		}
+		v.Status.BackendRollout = status
+		log.Info("Starting a rollout of the backend", "Strategy", strategy, "TemplateHash", hash)
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonRolloutStarted, "Started a %s rollout of %s", strategy, describe("Deployment", dep))
+	}
+
+	total := backendTotalReplicas(v, stable)
+	if status.Phase != examplecomv1.RolloutPhaseAborted {
+		result, err := r.stepBackendRollout(ctx, req, v, dep, stable, total)
+		if result != nil || err != nil {
+			return stable, result, err
+		}
+	}
+
+	// An aborted rollout leaves the backend on the version it ran, the
+	// candidate is deleted once the rollout is no longer in progress
+	if status.Phase == examplecomv1.RolloutPhaseAborted && !backendAutoScaling(v) {
+		err = r.scaleDeployment(ctx, v, stable, total)
+		if err != nil {
+			return nil, &ctrl.Result{}, err
+		}
+	}
+	return stable, nil, nil
+}
+
+// Moves a Canary or BlueGreen rollout of the backend forward: applies the
+// candidate pods of the current step, holds the step once they are available
+// and moves on to the next one, or promotes dep after the last one. Aborts
+// the rollout when the candidate pods do not become or stay available.
+func (r *VisitorsAppReconciler) stepBackendRollout(ctx context.Context,
+	req ctrl.Request,
+	v *examplecomv1.VisitorsApp,
+	dep *appsv1.Deployment,
+	stable *appsv1.Deployment,
+	total int32,
+) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	status := v.Status.BackendRollout
+
+	weight, pause, last := rolloutStep(v)
+	replicas := candidateReplicas(total, weight)
+	candidate := backendCandidateDeployment(v, dep, status.Strategy, replicas)
+	result, err := r.ensureDeployment(ctx, req, v, candidate)
+	if result != nil {
+		return result, err
+	}
+
+	if deploymentProgressDeadlineExceeded(candidate) {
+		r.abortBackendRollout(v, fmt.Sprintf("Deployment %s did not become available in time", candidate.Name))
+		return nil, nil
+	}
+	if !deploymentRolledOut(candidate) {
+		if status.Phase == examplecomv1.RolloutPhasePaused {
+			r.abortBackendRollout(v, fmt.Sprintf("Deployment %s became unavailable", candidate.Name))
+			return nil, nil
+		}
+		status.Message = fmt.Sprintf("Waiting for %d available pods of Deployment %s", replicas, candidate.Name)
+		log.Info("Waiting for the candidate pods of the backend rollout", "Deployment.Name", candidate.Name)
+		return &ctrl.Result{}, nil
+	}
+
+	// Canary pods take over from the stable ones, while a BlueGreen preview
+	// runs next to all of them
+	if status.Strategy == examplecomv1.RolloutStrategyCanary {
+		status.Weight = weight
+		if !backendAutoScaling(v) {
+			stableReplicas := total - replicas
+			if stableReplicas < 0 {
+				stableReplicas = 0
+			}
+			err = r.scaleDeployment(ctx, v, stable, stableReplicas)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+	}
+
+	now := r.clock().Now()
+	if status.StepStartTime == nil {
+		status.StepStartTime = &metav1.Time{Time: now}
+		status.Phase = examplecomv1.RolloutPhasePaused
+	}
+	if remaining := status.StepStartTime.Add(pause).Sub(now); remaining > 0 {
+		status.Message = fmt.Sprintf("Holding %d%% of the pods on the new version for %s", weight, pause)
+		if status.Strategy == examplecomv1.RolloutStrategyBlueGreen {
+			status.Message = fmt.Sprintf("Previewing the new version without traffic for %s", pause)
+		}
+		return &ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	status.StepStartTime = nil
+	if !last {
+		status.Step++
+		status.Phase = examplecomv1.RolloutPhaseProgressing
+		status.Message = fmt.Sprintf("Moving to step %d", status.Step)
+		return &ctrl.Result{Requeue: true}, nil
+	}
+
+	// The candidate pods keep serving while dep rolls out, the backend
+	// Service selects them alone during the promotion of a BlueGreen rollout
+	status.Phase = examplecomv1.RolloutPhasePromoting
+	status.Weight = 100
+	status.Message = fmt.Sprintf("Updating Deployment %s to the new version", dep.Name)
+	result, err = r.ensureTierDeployment(ctx, req, v, "backend", dep)
+	if result != nil {
+		return result, err
+	}
+	return &ctrl.Result{}, nil
+}
+
+// Returns the share of candidate pods of the current step of the rollout, how
+// long they are held once available, and whether it is the last step
+func rolloutStep(v *examplecomv1.VisitorsApp) (int32, time.Duration, bool) {
+	spec := v.Spec.Backend.RolloutStrategy
+	status := v.Status.BackendRollout
+
+	if status.Strategy == examplecomv1.RolloutStrategyBlueGreen {
+		if spec.BlueGreen == nil {
+			return 100, 0, true
+		}
+		return 100, spec.BlueGreen.PreviewDuration.Duration, true
+	}
+
+	if spec.Canary == nil || len(spec.Canary.Steps) == 0 {
+		return 100, 0, true
+	}
+	steps := spec.Canary.Steps
+	// Steps may have been removed from the spec during the rollout
+	if int(status.Step) >= len(steps) {
+		status.Step = int32(len(steps) - 1)
+	}
+	step := steps[status.Step]
+	return step.Weight, step.Pause.Duration, int(status.Step) == len(steps)-1
+}
+
+func (r *VisitorsAppReconciler) abortBackendRollout(v *examplecomv1.VisitorsApp, message string) {
+	status := v.Status.BackendRollout
+	status.Phase = examplecomv1.RolloutPhaseAborted
+	status.Message = message
+	status.Weight = 0
+	status.StepStartTime = nil
+	r.Recorder.Eventf(v, corev1.EventTypeWarning, eventReasonRolloutAborted, "Aborted the %s rollout of the backend: %s", status.Strategy, message)
+}
+
+// Sets the replicas of a Deployment whose template is left as it is
+func (r *VisitorsAppReconciler) scaleDeployment(ctx context.Context, v *examplecomv1.VisitorsApp, dep *appsv1.Deployment, replicas int32) error {
+	if dep.Spec.Replicas != nil && *dep.Spec.Replicas == replicas {
+		return nil
+	}
+
+	old := dep.DeepCopy()
+	dep.Spec.Replicas = &replicas
+	err := r.Patch(ctx, dep, client.MergeFrom(old), client.FieldOwner(fieldManager))
+	if err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to scale Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		return err
+	}
+	r.recordDeploymentChanges(v, old, dep)
+	return nil
+}
+
+func deploymentProgressDeadlineExceeded(dep *appsv1.Deployment) bool {
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing {
+			return c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Rollout gating", func() {
+	rolledOut := func() *appsv1.Deployment {
+		replicas := int32(2)
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors-backend", Generation: 3},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: 3,
+				Replicas:           2,
+				UpdatedReplicas:    2,
+				AvailableReplicas:  2,
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	}
+
+	It("waits for every pod to run the current spec", func() {
+		Expect(deploymentRolledOut(rolledOut())).To(BeTrue())
+
+		dep := rolledOut()
+		dep.Generation = 4
+		Expect(deploymentRolledOut(dep)).To(BeFalse())
+
+		// An old pod is still terminating
+		dep = rolledOut()
+		dep.Status.Replicas = 3
+		Expect(deploymentRolledOut(dep)).To(BeFalse())
+
+		dep = rolledOut()
+		dep.Status.AvailableReplicas = 1
+		Expect(deploymentRolledOut(dep)).To(BeFalse())
+
+		dep = rolledOut()
+		dep.Status.Conditions[0].Status = corev1.ConditionFalse
+		Expect(deploymentRolledOut(dep)).To(BeFalse())
+	})
+
+	It("reports the first tier that is not ready as the phase", func() {
+		v := &examplecomv1.VisitorsApp{}
+		setPhase(v, true, true)
+		Expect(v.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseWaitingForDatabase))
+
+		setDatabaseCondition(v, DatabaseStatus{Ready: true, Reason: "MysqlReady"})
+		setPhase(v, false, true)
+		Expect(v.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseRollingOutBackend))
+
+		setPhase(v, true, false)
+		Expect(v.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseRollingOutFrontend))
+
+		setPhase(v, true, true)
+		Expect(v.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseRunning))
+
+		v.Spec.Database.Schema = &examplecomv1.SchemaSpec{}
+		setPhase(v, true, true)
+		Expect(v.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseWaitingForDatabase))
+	})
+})
+
+var _ = Describe("Backend rollouts", func() {
+	var (
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{
+					TierSpec: examplecomv1.TierSpec{Size: 4},
+					RolloutStrategy: examplecomv1.RolloutStrategy{
+						Type: examplecomv1.RolloutStrategyCanary,
+						Canary: &examplecomv1.CanaryStrategy{Steps: []examplecomv1.CanaryStep{
+							{Weight: 25, Pause: metav1.Duration{Duration: 10 * time.Minute}},
+							{Weight: 50},
+						}},
+					},
+				},
+			},
+		}
+	})
+
+	It("sends the Canary pods a share of the backend traffic", func() {
+		canary := backendCandidateDeployment(app, r.backendDeployment(app), examplecomv1.RolloutStrategyCanary, 1)
+		Expect(canary.Name).To(Equal("visitors-backend-canary"))
+		Expect(*canary.Spec.Replicas).To(Equal(int32(1)))
+		Expect(canary.Spec.Template.Labels).To(HaveKeyWithValue(rolloutTrackLabel, "canary"))
+		for key, value := range r.backendService(app).Spec.Selector {
+			Expect(canary.Spec.Template.Labels).To(HaveKeyWithValue(key, value))
+		}
+	})
+
+	It("switches the backend traffic to the BlueGreen preview while promoting it", func() {
+		preview := backendCandidateDeployment(app, r.backendDeployment(app), examplecomv1.RolloutStrategyBlueGreen, 4)
+		Expect(preview.Name).To(Equal("visitors-backend-preview"))
+		Expect(r.backendService(app).Spec.Selector).NotTo(Equal(preview.Spec.Template.Labels))
+
+		app.Status.BackendRollout = &examplecomv1.RolloutStatus{
+			Strategy: examplecomv1.RolloutStrategyBlueGreen,
+			Phase:    examplecomv1.RolloutPhasePromoting,
+		}
+		Expect(r.backendService(app).Spec.Selector).To(Equal(preview.Spec.Template.Labels))
+
+		app.Status.BackendRollout.Phase = examplecomv1.RolloutPhaseCompleted
+		Expect(r.backendService(app).Spec.Selector).To(Equal(labels(app, "backend")))
+	})
+
+	It("sizes the candidate pods after the weight of the step", func() {
+		Expect(candidateReplicas(4, 25)).To(Equal(int32(1)))
+		Expect(candidateReplicas(3, 50)).To(Equal(int32(2)))
+		Expect(candidateReplicas(2, 1)).To(Equal(int32(1)))
+		Expect(candidateReplicas(4, 100)).To(Equal(int32(4)))
+
+		app.Status.BackendRollout = &examplecomv1.RolloutStatus{Strategy: examplecomv1.RolloutStrategyCanary}
+		weight, pause, last := rolloutStep(app)
+		Expect(weight).To(Equal(int32(25)))
+		Expect(pause).To(Equal(10 * time.Minute))
+		Expect(last).To(BeFalse())
+
+		// A step removed from the spec during the rollout
+		app.Status.BackendRollout.Step = 3
+		weight, _, last = rolloutStep(app)
+		Expect(weight).To(Equal(int32(50)))
+		Expect(last).To(BeTrue())
+		Expect(app.Status.BackendRollout.Step).To(Equal(int32(1)))
+	})
+
+	It("only rolls out again once the pod template changes", func() {
+		dep := r.backendDeployment(app)
+		app.Spec.Backend.Size = 6
+		Expect(templateHash(r.backendDeployment(app))).To(Equal(templateHash(dep)))
+
+		app.Spec.Backend.Image.Tag = "1.1.0"
+		Expect(templateHash(r.backendDeployment(app))).NotTo(Equal(templateHash(dep)))
+	})
+})
+
+var _ = Describe("Backend rollout reconciles", func() {
+	var (
+		ctx      context.Context
+		app      *examplecomv1.VisitorsApp
+		r        *VisitorsAppReconciler
+		recorder *record.FakeRecorder
+		clock    *clocktesting.FakePassiveClock
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "default", UID: "canary-uid"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend: examplecomv1.BackendSpec{
+					TierSpec: examplecomv1.TierSpec{
+						Size:  4,
+						Image: examplecomv1.ImageSpec{Repository: "registry.example.com/visitors-service", Tag: "1.0.0"},
+					},
+					RolloutStrategy: examplecomv1.RolloutStrategy{
+						Type: examplecomv1.RolloutStrategyCanary,
+						Canary: &examplecomv1.CanaryStrategy{Steps: []examplecomv1.CanaryStep{
+							{Weight: 25, Pause: metav1.Duration{Duration: 10 * time.Minute}},
+							{Weight: 50},
+						}},
+					},
+				},
+			},
+		}
+		r = newTestReconciler(app)
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady"}
+		recorder = r.Recorder.(*record.FakeRecorder)
+		clock = clocktesting.NewFakePassiveClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+		r.Clock = clock
+
+		// The backend runs 1.0.0 before every spec releases 1.1.0
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, frontendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+	})
+
+	deployment := func(name string) *appsv1.Deployment {
+		dep := &appsv1.Deployment{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, dep)).To(Succeed())
+		return dep
+	}
+
+	candidateExists := func() bool {
+		err := r.Get(ctx, types.NamespacedName{Name: backendCanaryDeploymentName(app), Namespace: app.Namespace}, &appsv1.Deployment{})
+		return err == nil
+	}
+
+	events := func() []string {
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		return events
+	}
+
+	image := func(dep *appsv1.Deployment) string {
+		return dep.Spec.Template.Spec.Containers[0].Image
+	}
+
+	release := func(tag string) {
+		v := &examplecomv1.VisitorsApp{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, v)).To(Succeed())
+		v.Spec.Backend.Image.Tag = tag
+		Expect(r.Update(ctx, v)).To(Succeed())
+	}
+
+	// Releases 1.1.0 and holds its first step once the canary pod is available
+	pauseFirstStep := func() *examplecomv1.VisitorsApp {
+		release("1.1.0")
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseProgressing))
+		Expect(events()).To(ContainElement("Normal RolloutStarted Started a Canary rollout of Deployment canary-backend"))
+
+		rollOutDeployment(ctx, r, backendCanaryDeploymentName(app), app.Namespace)
+		v, result := reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhasePaused))
+		Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+		return v
+	}
+
+	It("moves a Canary rollout through its steps and promotes it", func() {
+		v := pauseFirstStep()
+		Expect(v.Status.BackendRollout.Step).To(BeZero())
+		Expect(v.Status.BackendRollout.Weight).To(Equal(int32(25)))
+		canary := deployment(backendCanaryDeploymentName(app))
+		Expect(*canary.Spec.Replicas).To(Equal(int32(1)))
+		Expect(image(canary)).To(Equal("registry.example.com/visitors-service:1.1.0"))
+
+		// The stable pods make up the rest of the backend, on the previous version
+		stable := deployment(backendDeploymentName(app))
+		Expect(*stable.Spec.Replicas).To(Equal(int32(3)))
+		Expect(image(stable)).To(Equal("registry.example.com/visitors-service:1.0.0"))
+
+		// The step is held for its pause
+		clock.SetTime(clock.Now().Add(5 * time.Minute))
+		v, result := reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhasePaused))
+		Expect(result.RequeueAfter).To(Equal(5 * time.Minute))
+
+		clock.SetTime(clock.Now().Add(5 * time.Minute))
+		v, result = reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseProgressing))
+		Expect(v.Status.BackendRollout.Step).To(Equal(int32(1)))
+		Expect(result.Requeue).To(BeTrue())
+
+		reconcileApp(ctx, r, app)
+		Expect(*deployment(backendCanaryDeploymentName(app)).Spec.Replicas).To(Equal(int32(2)))
+
+		// The last step has no pause, the stable Deployment is updated at once
+		rollOutDeployment(ctx, r, backendCanaryDeploymentName(app), app.Namespace)
+		v, _ = reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhasePromoting))
+		Expect(v.Status.BackendRollout.Weight).To(Equal(int32(100)))
+		stable = deployment(backendDeploymentName(app))
+		Expect(*stable.Spec.Replicas).To(Equal(int32(4)))
+		Expect(image(stable)).To(Equal("registry.example.com/visitors-service:1.1.0"))
+		Expect(candidateExists()).To(BeTrue())
+
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		v, _ = reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseCompleted))
+		Expect(events()).To(ContainElement("Normal RolloutCompleted Promoted the Canary rollout of Deployment canary-backend"))
+		Expect(candidateExists()).To(BeFalse())
+	})
+
+	It("aborts a rollout whose pods miss the progress deadline", func() {
+		release("1.1.0")
+		reconcileApp(ctx, r, app)
+
+		canary := deployment(backendCandidateDeploymentName(app, examplecomv1.RolloutStrategyCanary))
+		canary.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentProgressing,
+			Status: corev1.ConditionFalse,
+			Reason: "ProgressDeadlineExceeded",
+		}}
+		Expect(r.Status().Update(ctx, canary)).To(Succeed())
+
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseAborted))
+		Expect(v.Status.BackendRollout.Message).To(Equal("Deployment canary-backend-canary did not become available in time"))
+		Expect(events()).To(ContainElements(
+			"Normal RolloutStarted Started a Canary rollout of Deployment canary-backend",
+			"Warning RolloutAborted Aborted the Canary rollout of the backend: "+
+				"Deployment canary-backend-canary did not become available in time",
+		))
+
+		stable := deployment(backendDeploymentName(app))
+		Expect(*stable.Spec.Replicas).To(Equal(int32(4)))
+		Expect(image(stable)).To(Equal("registry.example.com/visitors-service:1.0.0"))
+		Expect(candidateExists()).To(BeFalse())
+	})
+
+	It("aborts a rollout whose pods become unavailable during a step", func() {
+		pauseFirstStep()
+
+		canary := deployment(backendCanaryDeploymentName(app))
+		canary.Status.AvailableReplicas = 0
+		canary.Status.Conditions[0].Status = corev1.ConditionFalse
+		Expect(r.Status().Update(ctx, canary)).To(Succeed())
+
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseAborted))
+		Expect(v.Status.BackendRollout.Weight).To(BeZero())
+		Expect(events()).To(ContainElement("Warning RolloutAborted Aborted the Canary rollout of the backend: " +
+			"Deployment canary-backend-canary became unavailable"))
+
+		// The stable pods take back the whole backend
+		stable := deployment(backendDeploymentName(app))
+		Expect(*stable.Spec.Replicas).To(Equal(int32(4)))
+		Expect(image(stable)).To(Equal("registry.example.com/visitors-service:1.0.0"))
+		Expect(candidateExists()).To(BeFalse())
+	})
+
+	It("starts over when the pod template changes during a rollout", func() {
+		v := pauseFirstStep()
+		previous := v.Status.BackendRollout.TemplateHash
+
+		release("1.2.0")
+		v, _ = reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.TemplateHash).NotTo(Equal(previous))
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseProgressing))
+		Expect(v.Status.BackendRollout.Step).To(BeZero())
+		Expect(v.Status.BackendRollout.StepStartTime).To(BeNil())
+		Expect(image(deployment(backendCanaryDeploymentName(app)))).To(Equal("registry.example.com/visitors-service:1.2.0"))
+
+		// Going back to the version the stable pods run ends the rollout
+		release("1.0.0")
+		v, _ = reconcileApp(ctx, r, app)
+		Expect(v.Status.BackendRollout.Phase).To(Equal(examplecomv1.RolloutPhaseAborted))
+		Expect(v.Status.BackendRollout.Message).To(Equal("Superseded by a change of the backend"))
+		Expect(*deployment(backendDeploymentName(app)).Spec.Replicas).To(Equal(int32(4)))
+		Expect(candidateExists()).To(BeFalse())
+	})
+})
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Tier label of the VisitorsAppBackups created on the schedule of a VisitorsApp
+const scheduledBackupTier = "scheduled-backup"
+
+// Time a scheduled VisitorsAppBackup was due at, in RFC 3339
+const scheduledAtAnnotation = "example.com.my.domain/scheduled-at"
+
+func scheduledBackupName(v *examplecomv1.VisitorsApp, scheduledAt time.Time) string {
+	return fmt.Sprintf("%s-%d", v.Name, scheduledAt.Unix())
+}
+
+// Returns the time a scheduled backup was due at, falling back to its
+// creation for one whose annotation was removed
+func scheduledAt(b *examplecomv1.VisitorsAppBackup) time.Time {
+	t, err := time.Parse(time.RFC3339, b.Annotations[scheduledAtAnnotation])
+	if err != nil {
+		return b.CreationTimestamp.Time
+	}
+	return t
+}
+
+func backupFinished(b *examplecomv1.VisitorsAppBackup) bool {
+	return b.Status.Phase == examplecomv1.BackupPhaseCompleted || b.Status.Phase == examplecomv1.BackupPhaseFailed
+}
+
+// Creates the VisitorsAppBackup due on the schedule of v, then deletes the
+// scheduled backups beyond its retention. Returns the time left until the
+// next one is due, or 0 without a schedule.
+//
+// Runs missed while the operator was down are caught up with a single backup.
+// A run is skipped while the previous backup has not finished, so that
+// backups do not pile up while the database is down.
+func (r *VisitorsAppReconciler) ensureScheduledBackups(ctx context.Context, v *examplecomv1.VisitorsApp) (time.Duration, error) {
+	log := ctrllog.FromContext(ctx)
+
+	if v.Spec.Backup == nil {
+		v.Status.LastScheduledBackupTime = nil
+		return 0, nil
+	}
+
+	schedule, err := cron.ParseStandard(v.Spec.Backup.Schedule)
+	if err != nil {
+		// Rejected by the webhook, retrying would not help
+		log.Error(err, "Invalid backup schedule", "Schedule", v.Spec.Backup.Schedule)
+		return 0, nil
+	}
+
+	backups := &examplecomv1.VisitorsAppBackupList{}
+	err = r.List(ctx, backups, client.InNamespace(v.Namespace), client.MatchingLabels(labels(v, scheduledBackupTier)))
+	if err != nil {
+		log.Error(err, "Failed to list the scheduled VisitorsAppBackups")
+		return 0, err
+	}
+
+	now := r.clock().Now()
+	if v.Status.LastScheduledBackupTime == nil {
+		// The first backup is due on the schedule following its set up
+		v.Status.LastScheduledBackupTime = &metav1.Time{Time: now}
+	}
+
+	var due time.Time
+	for t := schedule.Next(v.Status.LastScheduledBackupTime.Time); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		due = t
+	}
+
+	if !due.IsZero() {
+		v.Status.LastScheduledBackupTime = &metav1.Time{Time: due}
+
+		running := ""
+		for i := range backups.Items {
+			if !backupFinished(&backups.Items[i]) {
+				running = backups.Items[i].Name
+			}
+		}
+
+		if running != "" {
+			log.Info("Skipping the scheduled backup, the previous one has not finished", "VisitorsAppBackup", running)
+		} else {
+			b, err := r.createScheduledBackup(ctx, v, due)
+			if err != nil {
+				return 0, err
+			}
+			backups.Items = append(backups.Items, *b)
+		}
+	}
+
+	err = r.pruneScheduledBackups(ctx, v, backups.Items, now)
+	if err != nil {
+		return 0, err
+	}
+
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return 0, nil
+	}
+	return next.Sub(now), nil
+}
+
+// Creates the backup due at the given time. Scheduled backups are not owned
+// by the VisitorsApp, they outlive it to be able to restore it.
+func (r *VisitorsAppReconciler) createScheduledBackup(ctx context.Context, v *examplecomv1.VisitorsApp, due time.Time) (*examplecomv1.VisitorsAppBackup, error) {
+	log := ctrllog.FromContext(ctx)
+
+	b := &examplecomv1.VisitorsAppBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        scheduledBackupName(v, due),
+			Namespace:   v.Namespace,
+			Labels:      labels(v, scheduledBackupTier),
+			Annotations: map[string]string{scheduledAtAnnotation: due.UTC().Format(time.RFC3339)},
+		},
+		Spec: examplecomv1.VisitorsAppBackupSpec{
+			VisitorsAppName: v.Name,
+			Target:          *v.Spec.Backup.Target.DeepCopy(),
+			Image:           v.Spec.Backup.Image,
+			DeletionPolicy:  examplecomv1.BackupDeletionPolicyDelete,
+		},
+	}
+
+	log.Info("Creating a new VisitorsAppBackup", "VisitorsAppBackup.Namespace", b.Namespace, "VisitorsAppBackup.Name", b.Name)
+	err := r.Create(ctx, b)
+	if errors.IsAlreadyExists(err) {
+		// Created by a reconcile whose status update was lost
+		return b, nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to create new VisitorsAppBackup", "VisitorsAppBackup.Namespace", b.Namespace, "VisitorsAppBackup.Name", b.Name)
+		return nil, err
+	}
+	r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonBackupScheduled, "Created "+describe("VisitorsAppBackup", b))
+	return b, nil
+}
+
+// Deletes the finished scheduled backups beyond the retention of v, along with
+// their dumps. The latest completed backup is always kept, and so are failed
+// backups until a later one completed.
+func (r *VisitorsAppReconciler) pruneScheduledBackups(ctx context.Context, v *examplecomv1.VisitorsApp, backups []examplecomv1.VisitorsAppBackup, now time.Time) error {
+	log := ctrllog.FromContext(ctx)
+	retention := v.Spec.Backup.Retention
+	if retention.KeepLast == nil && retention.MaxAge == nil {
+		keepLast := int32(examplecomv1.DefaultBackupKeepLast)
+		retention.KeepLast = &keepLast
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return scheduledAt(&backups[i]).After(scheduledAt(&backups[j]))
+	})
+
+	completed := 0
+	for i := range backups {
+		b := &backups[i]
+		if !b.DeletionTimestamp.IsZero() || !backupFinished(b) {
+			continue
+		}
+
+		var prune bool
+		if b.Status.Phase == examplecomv1.BackupPhaseCompleted {
+			completed++
+			tooMany := retention.KeepLast != nil && completed > int(*retention.KeepLast)
+			tooOld := retention.MaxAge != nil && now.Sub(scheduledAt(b)) > retention.MaxAge.Duration
+			prune = completed > 1 && (tooMany || tooOld)
+		} else {
+			prune = completed > 0
+		}
+		if !prune {
+			continue
+		}
+
+		log.Info("Pruning the VisitorsAppBackup", "VisitorsAppBackup.Namespace", b.Namespace, "VisitorsAppBackup.Name", b.Name)
+		err := r.Delete(ctx, b)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete VisitorsAppBackup", "VisitorsAppBackup.Namespace", b.Namespace, "VisitorsAppBackup.Name", b.Name)
+			return err
+		}
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonBackupPruned, "Deleted "+describe("VisitorsAppBackup", b))
+	}
+	return nil
+}
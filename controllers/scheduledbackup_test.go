@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Scheduled backups", func() {
+	var (
+		ctx   context.Context
+		c     client.Client
+		clock *clocktesting.FakePassiveClock
+		r     *VisitorsAppReconciler
+		app   *examplecomv1.VisitorsApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		keepLast := int32(2)
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backup: &examplecomv1.ScheduledBackupSpec{
+					Schedule: "0 3 * * *",
+					Target: examplecomv1.BackupTarget{
+						PersistentVolumeClaim: &examplecomv1.PersistentVolumeClaimTarget{ClaimName: "backups"},
+					},
+					Retention: examplecomv1.BackupRetention{KeepLast: &keepLast},
+				},
+			},
+			Status: examplecomv1.VisitorsAppStatus{
+				LastScheduledBackupTime: &metav1.Time{Time: start},
+			},
+		}
+
+		clock = clocktesting.NewFakePassiveClock(start)
+		r = newTestReconciler()
+		r.Clock = clock
+		c = r.Client
+	})
+
+	scheduledBackups := func() []examplecomv1.VisitorsAppBackup {
+		backups := &examplecomv1.VisitorsAppBackupList{}
+		Expect(c.List(ctx, backups, client.MatchingLabels(labels(app, scheduledBackupTier)))).To(Succeed())
+		return backups.Items
+	}
+
+	// Runs the schedule the next day at 3am, then finishes the backup it created
+	runNextDay := func(phase examplecomv1.BackupPhase) {
+		clock.SetTime(clock.Now().Add(24 * time.Hour))
+		_, err := r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+
+		b := &examplecomv1.VisitorsAppBackup{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: scheduledBackupName(app, app.Status.LastScheduledBackupTime.Time), Namespace: "default"}, b)).To(Succeed())
+		b.Status.Phase = phase
+		Expect(c.Status().Update(ctx, b)).To(Succeed())
+	}
+
+	It("creates a backup when it is due and requeues until the next one", func() {
+		app.Status.LastScheduledBackupTime = nil
+		next, err := r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scheduledBackups()).To(BeEmpty())
+		Expect(next).To(Equal(15 * time.Hour))
+
+		clock.SetTime(clock.Now().Add(next))
+		next, err = r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(Equal(24 * time.Hour))
+
+		backups := scheduledBackups()
+		Expect(backups).To(HaveLen(1))
+		Expect(backups[0].Name).To(Equal(scheduledBackupName(app, clock.Now())))
+		Expect(backups[0].Spec.VisitorsAppName).To(Equal("visitors"))
+		Expect(backups[0].Spec.DeletionPolicy).To(Equal(examplecomv1.BackupDeletionPolicyDelete))
+		Expect(backups[0].OwnerReferences).To(BeEmpty())
+	})
+
+	It("skips a run while the previous backup has not finished", func() {
+		runNextDay(examplecomv1.BackupPhaseRunning)
+
+		clock.SetTime(clock.Now().Add(24 * time.Hour))
+		_, err := r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scheduledBackups()).To(HaveLen(1))
+		Expect(app.Status.LastScheduledBackupTime.Time).To(BeTemporally("==", time.Date(2021, 6, 3, 3, 0, 0, 0, time.UTC)))
+	})
+
+	It("deletes the completed backups beyond the ones kept", func() {
+		runNextDay(examplecomv1.BackupPhaseCompleted)
+		runNextDay(examplecomv1.BackupPhaseFailed)
+		runNextDay(examplecomv1.BackupPhaseCompleted)
+		runNextDay(examplecomv1.BackupPhaseCompleted)
+
+		_, err := r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		for _, b := range scheduledBackups() {
+			names = append(names, b.Name)
+		}
+		Expect(names).To(ConsistOf(
+			scheduledBackupName(app, time.Date(2021, 6, 4, 3, 0, 0, 0, time.UTC)),
+			scheduledBackupName(app, time.Date(2021, 6, 5, 3, 0, 0, 0, time.UTC)),
+		))
+	})
+
+	It("always keeps the latest completed backup", func() {
+		app.Spec.Backup.Retention = examplecomv1.BackupRetention{MaxAge: &metav1.Duration{Duration: time.Hour}}
+		runNextDay(examplecomv1.BackupPhaseCompleted)
+		runNextDay(examplecomv1.BackupPhaseCompleted)
+
+		clock.SetTime(clock.Now().Add(12 * time.Hour))
+		_, err := r.ensureScheduledBackups(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scheduledBackups()).To(HaveLen(1))
+	})
+})
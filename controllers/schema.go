@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const migrationsPath = "/migrations"
+
+// Script of the schema Job. Every statement can be run again, so the Job is
+// run again whenever its inputs change: the database is created if missing,
+// the user is created or gets its current password, and the migrations not
+// recorded in schema_migrations yet are applied in order.
+// The application user only gets privileges on its own database.
+const schemaScript = `set -e
+quote() { printf '%s' "$1" | sed -e 's/\\/\\\\/g' -e "s/'/''/g"; }
+sql() { mysql -h "$MYSQL_HOST" -u root "$@"; }
+
+user=$(quote "$APP_USER")
+password=$(quote "$APP_PASSWORD")
+sql -e "CREATE DATABASE IF NOT EXISTS $DATABASE;
+CREATE USER IF NOT EXISTS '$user'@'%' IDENTIFIED BY '$password';
+ALTER USER '$user'@'%' IDENTIFIED BY '$password';
+GRANT SELECT, INSERT, UPDATE, DELETE, CREATE, ALTER, INDEX, REFERENCES ON $DATABASE.* TO '$user'@'%';
+CREATE TABLE IF NOT EXISTS $DATABASE.schema_migrations (
+  version VARCHAR(255) NOT NULL PRIMARY KEY,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);"
+
+for file in $(ls ` + migrationsPath + ` 2>/dev/null | LC_ALL=C sort); do
+  version=$(quote "${file%.sql}")
+  applied=$(sql -N -B -e "SELECT COUNT(*) FROM $DATABASE.schema_migrations WHERE version = '$version'")
+  if [ "$applied" = "0" ]; then
+    echo "Applying $file"
+    sql -D "$DATABASE_NAME" < "` + migrationsPath + `/$file"
+    sql -e "INSERT INTO $DATABASE.schema_migrations (version) VALUES ('$version')"
+  fi
+done
+`
+
+func schemaImage(v *examplecomv1.VisitorsApp) string {
+	if v.Spec.Database.Schema.Image != "" {
+		return v.Spec.Database.Schema.Image
+	}
+	return "mysql:" + mysqlVersion(v)
+}
+
+// Returns the migrations of the ConfigMap, nil when the spec has none
+func (r *VisitorsAppReconciler) schemaMigrations(ctx context.Context, v *examplecomv1.VisitorsApp) (map[string]string, error) {
+	name := v.Spec.Database.Schema.MigrationsConfigMap
+	if name == "" {
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: v.Namespace}, cm)
+	if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// Returns the version of the last of the migrations
+func schemaVersion(migrations map[string]string) string {
+	version := ""
+	for key := range migrations {
+		if key > version {
+			version = key
+		}
+	}
+	return strings.TrimSuffix(version, ".sql")
+}
+
+// Names the Job after everything it depends on, so that a new Job runs when
+// the migrations, the credentials or the image change
+func schemaJobName(v *examplecomv1.VisitorsApp, credentialsHash string, migrations map[string]string) string {
+	hash := sha256.New()
+	for _, input := range []string{schemaImage(v), mysqlDatabaseName(v), credentialsHash} {
+		hash.Write([]byte(input))
+		hash.Write([]byte{0})
+	}
+
+	keys := make([]string, 0, len(migrations))
+	for key := range migrations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(migrations[key]))
+		hash.Write([]byte{0})
+	}
+
+	return v.Name + "-db-schema-" + hex.EncodeToString(hash.Sum(nil))[:10]
+}
+
+// Runs the schema Job for the current inputs and holds the backend back
+// until it succeeded. The Job is owned, so its completion triggers a reconcile.
+func (r *VisitorsAppReconciler) ensureSchema(ctx context.Context, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	migrations, err := r.schemaMigrations(ctx, v)
+	if errors.IsNotFound(err) {
+		setCondition(v, examplecomv1.ConditionSchemaReady, metav1.ConditionFalse, "MigrationsNotFound",
+			fmt.Sprintf("ConfigMap %s is missing", v.Spec.Database.Schema.MigrationsConfigMap))
+		return &ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to get the migrations")
+		return &ctrl.Result{}, err
+	}
+
+	hasRootPassword, err := hasMysqlRootPassword(ctx, r, v)
+	if err != nil {
+		log.Error(err, "Failed to read the database credentials")
+		return &ctrl.Result{}, err
+	}
+	if !hasRootPassword {
+		// The credentials Secret is watched, so the Job is created as soon
+		// as the password is filled in
+		setCondition(v, examplecomv1.ConditionSchemaReady, metav1.ConditionFalse, "RootPasswordNotFound",
+			fmt.Sprintf("Secret %s has no key %s", mysqlAuthName(v), mysqlRootPasswordKey(v)))
+		return &ctrl.Result{}, nil
+	}
+
+	credentialsHash, err := r.mysqlCredentialsHash(ctx, v)
+	if err != nil {
+		log.Error(err, "Failed to read the database credentials")
+		return &ctrl.Result{}, err
+	}
+
+	name := schemaJobName(v, credentialsHash, migrations)
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: v.Namespace}, job)
+	if err != nil && errors.IsNotFound(err) {
+		job = r.schemaJob(v, name)
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return &ctrl.Result{}, err
+		}
+		r.Recorder.Event(v, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("Job", job))
+	} else if err != nil {
+		log.Error(err, "Failed to get Job")
+		return &ctrl.Result{}, err
+	}
+
+	previous := meta.FindStatusCondition(v.Status.Conditions, examplecomv1.ConditionSchemaReady)
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			version := schemaVersion(migrations)
+			if previous == nil || previous.Reason != "SchemaUpToDate" || v.Status.SchemaVersion != version {
+				r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonSchemaMigrated,
+					"Job %s brought the schema of database %s to version %q", job.Name, mysqlDatabaseName(v), version)
+			}
+			v.Status.SchemaVersion = version
+			setCondition(v, examplecomv1.ConditionSchemaReady, metav1.ConditionTrue, "SchemaUpToDate",
+				fmt.Sprintf("Job %s succeeded", job.Name))
+			return r.ensureStaleSchemaJobsDeleted(ctx, v, job)
+		case batchv1.JobFailed:
+			if previous == nil || previous.Reason != "SchemaMigrationFailed" {
+				r.Recorder.Eventf(v, corev1.EventTypeWarning, eventReasonSchemaMigrationFailed,
+					"Job %s failed: %s", job.Name, c.Message)
+			}
+			// Nothing is retried until the migrations or the spec change
+			setCondition(v, examplecomv1.ConditionSchemaReady, metav1.ConditionFalse, "SchemaMigrationFailed",
+				fmt.Sprintf("Job %s failed: %s", job.Name, c.Message))
+			return &ctrl.Result{}, nil
+		}
+	}
+
+	log.Info("Waiting for the schema Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	setCondition(v, examplecomv1.ConditionSchemaReady, metav1.ConditionFalse, "SchemaMigrating",
+		fmt.Sprintf("Job %s is running", job.Name))
+	return &ctrl.Result{}, nil
+}
+
+// Deletes the schema Jobs run for earlier inputs once current succeeded
+func (r *VisitorsAppReconciler) ensureStaleSchemaJobsDeleted(ctx context.Context, v *examplecomv1.VisitorsApp, current *batchv1.Job) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	jobs := &batchv1.JobList{}
+	err := r.List(ctx, jobs, client.InNamespace(v.Namespace), client.MatchingLabels(labels(v, "schema")))
+	if err != nil {
+		log.Error(err, "Failed to list the schema Jobs")
+		return &ctrl.Result{}, err
+	}
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name == current.Name || !metav1.IsControlledBy(job, v) {
+			continue
+		}
+
+		log.Info("Deleting a stale schema Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return &ctrl.Result{}, err
+		}
+	}
+	return nil, nil
+}
+
+// Drops what was reported about the schema once the spec has no schema section
+func clearSchemaStatus(v *examplecomv1.VisitorsApp) {
+	meta.RemoveStatusCondition(&v.Status.Conditions, examplecomv1.ConditionSchemaReady)
+	v.Status.SchemaVersion = ""
+}
+
+// Renders the schema Job. It connects as root, the application credentials
+// are only read to create the user.
+func (r *VisitorsAppReconciler) schemaJob(v *examplecomv1.VisitorsApp, name string) *batchv1.Job {
+	secretKey := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName(v)},
+				Key:                  key,
+			},
+		}
+	}
+
+	container := corev1.Container{
+		Name:    "schema",
+		Image:   schemaImage(v),
+		Command: []string{"sh", "-c", schemaScript},
+		Env: []corev1.EnvVar{
+			{Name: "MYSQL_HOST", Value: mysqlServiceHost(v, mysqlServiceRWName(v))},
+			{Name: "MYSQL_PWD", ValueFrom: secretKey(mysqlRootPasswordKey(v))},
+			{Name: "DATABASE", Value: quoteIdentifier(mysqlDatabaseName(v))},
+			{Name: "DATABASE_NAME", Value: mysqlDatabaseName(v)},
+			{Name: "APP_USER", ValueFrom: secretKey(mysqlUserKey(v))},
+			{Name: "APP_PASSWORD", ValueFrom: secretKey(mysqlPasswordKey(v))},
+		},
+	}
+
+	var volumes []corev1.Volume
+	if cm := v.Spec.Database.Schema.MigrationsConfigMap; cm != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: "migrations",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm},
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "migrations",
+			MountPath: migrationsPath,
+			ReadOnly:  true,
+		})
+	}
+
+	labels := labels(v, "schema")
+	backoffLimit := int32(3)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: v.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+
+	controllerutil.SetControllerReference(v, job, r.Scheme)
+	return job
+}
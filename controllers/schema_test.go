@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("VisitorsApp schema", func() {
+	var (
+		ctx         context.Context
+		app         *examplecomv1.VisitorsApp
+		migrations  *corev1.ConfigMap
+		credentials *corev1.Secret
+		r           *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "schema",
+				Namespace: "default",
+				UID:       types.UID("schema-uid"),
+			},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{
+					Schema: &examplecomv1.SchemaSpec{MigrationsConfigMap: "visitors-migrations"},
+				},
+			},
+		}
+		migrations = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "visitors-migrations", Namespace: "default"},
+			Data: map[string]string{
+				"0001_visitors.sql": "CREATE TABLE IF NOT EXISTS visitors (id INT PRIMARY KEY);",
+				"0002_index.sql":    "CREATE INDEX visitors_id ON visitors (id);",
+			},
+		}
+
+		credentials = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mysqlAuthName(app), Namespace: "default"},
+			Data: map[string][]byte{
+				"USER":          []byte("visitors"),
+				"PASSWORD":      []byte("visitors-password"),
+				"ROOT_PASSWORD": []byte("root-password"),
+			},
+		}
+
+		r = newTestReconciler(app, migrations, credentials)
+	})
+
+	schemaJobs := func() []batchv1.Job {
+		jobs := &batchv1.JobList{}
+		Expect(r.List(ctx, jobs, client.MatchingLabels(labels(app, "schema")))).To(Succeed())
+		return jobs.Items
+	}
+
+	complete := func(job *batchv1.Job) {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		Expect(r.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	It("holds the backend back until the schema Job succeeded", func() {
+		result, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).NotTo(BeNil())
+		Expect(meta.FindStatusCondition(app.Status.Conditions, examplecomv1.ConditionSchemaReady).Reason).To(Equal("SchemaMigrating"))
+
+		jobs := schemaJobs()
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].Name).To(HavePrefix("schema-db-schema-"))
+		Expect(jobs[0].Spec.Template.Spec.Volumes[0].ConfigMap.Name).To(Equal("visitors-migrations"))
+
+		complete(&jobs[0])
+		result, err = r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(BeNil())
+		Expect(meta.IsStatusConditionTrue(app.Status.Conditions, examplecomv1.ConditionSchemaReady)).To(BeTrue())
+		Expect(app.Status.SchemaVersion).To(Equal("0002_index"))
+	})
+
+	It("runs a new Job for new migrations and deletes the previous one", func() {
+		_, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		previous := schemaJobs()[0]
+
+		migrations.Data["0003_name.sql"] = "ALTER TABLE visitors ADD COLUMN name VARCHAR(255);"
+		Expect(r.Update(ctx, migrations)).To(Succeed())
+
+		_, err = r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		jobs := schemaJobs()
+		Expect(jobs).To(HaveLen(2))
+
+		for i := range jobs {
+			if jobs[i].Name != previous.Name {
+				complete(&jobs[i])
+			}
+		}
+		result, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(BeNil())
+		Expect(app.Status.SchemaVersion).To(Equal("0003_name"))
+
+		jobs = schemaJobs()
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].Name).NotTo(Equal(previous.Name))
+	})
+
+	It("reports missing migrations", func() {
+		Expect(r.Delete(ctx, migrations)).To(Succeed())
+
+		result, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).NotTo(BeNil())
+		Expect(meta.FindStatusCondition(app.Status.Conditions, examplecomv1.ConditionSchemaReady).Reason).To(Equal("MigrationsNotFound"))
+		Expect(schemaJobs()).To(BeEmpty())
+	})
+
+	It("waits for the root password before running a Job", func() {
+		delete(credentials.Data, "ROOT_PASSWORD")
+		Expect(r.Update(ctx, credentials)).To(Succeed())
+
+		result, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).NotTo(BeNil())
+		condition := meta.FindStatusCondition(app.Status.Conditions, examplecomv1.ConditionSchemaReady)
+		Expect(condition.Reason).To(Equal("RootPasswordNotFound"))
+		Expect(condition.Message).To(Equal("Secret my-secret has no key ROOT_PASSWORD"))
+		Expect(schemaJobs()).To(BeEmpty())
+	})
+
+	It("connects with the root password key of the spec", func() {
+		app.Spec.Database.RootPasswordKey = "MYSQL_ROOT_PASSWORD"
+		credentials.Data["MYSQL_ROOT_PASSWORD"] = credentials.Data["ROOT_PASSWORD"]
+		delete(credentials.Data, "ROOT_PASSWORD")
+		Expect(r.Update(ctx, credentials)).To(Succeed())
+
+		_, err := r.ensureSchema(ctx, app)
+		Expect(err).NotTo(HaveOccurred())
+		jobs := schemaJobs()
+		Expect(jobs).To(HaveLen(1))
+		Expect(jobs[0].Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+			Name: "MYSQL_PWD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "my-secret"},
+					Key:                  "MYSQL_ROOT_PASSWORD",
+				},
+			},
+		}))
+	})
+})
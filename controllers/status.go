@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Aggregates the status of both tiers and writes it through the status
+// subresource. Nothing is written when the status did not change, so that
+// status updates do not retrigger the reconcile on their own.
+func (r *VisitorsAppReconciler) updateStatus(ctx context.Context, original *examplecomv1.VisitorsApp, v *examplecomv1.VisitorsApp) error {
+	backendRolledOut, err := r.updateBackendStatus(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	frontendRolledOut, err := r.updateFrontendStatus(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	setIngressURLs(v)
+
+	v.Status.ObservedGeneration = v.Generation
+	setReadyCondition(v)
+	setDegradedCondition(v)
+	setPhase(v, backendRolledOut, frontendRolledOut)
+	observeStatus(v, r.clock().Now())
+
+	if equality.Semantic.DeepEqual(original.Status, v.Status) {
+		return nil
+	}
+
+	err = r.Status().Patch(ctx, v, client.MergeFrom(original))
+	if err != nil {
+		return err
+	}
+
+	r.recordReadyTransition(original, v)
+	return nil
+}
+
+func setCondition(v *examplecomv1.VisitorsApp, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&v.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: v.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// Mirrors the Available condition of a Deployment onto the VisitorsApp
+func setDeploymentCondition(v *examplecomv1.VisitorsApp, conditionType string, dep *appsv1.Deployment) {
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable {
+			setCondition(v, conditionType, metav1.ConditionStatus(c.Status), c.Reason, c.Message)
+			return
+		}
+	}
+
+	setCondition(v, conditionType, metav1.ConditionUnknown, "DeploymentPending",
+		fmt.Sprintf("Deployment %s has not reported its availability yet", dep.Name))
+}
+
+// The Ready condition is only True when every tier is
+func setReadyCondition(v *examplecomv1.VisitorsApp) {
+	conditionTypes := []string{examplecomv1.ConditionDatabaseReady}
+	if v.Spec.Database.Schema != nil {
+		conditionTypes = append(conditionTypes, examplecomv1.ConditionSchemaReady)
+	}
+	conditionTypes = append(conditionTypes, examplecomv1.ConditionBackendAvailable, examplecomv1.ConditionFrontendAvailable)
+
+	for _, conditionType := range conditionTypes {
+		if !meta.IsStatusConditionTrue(v.Status.Conditions, conditionType) {
+			setCondition(v, examplecomv1.ConditionReady, metav1.ConditionFalse, conditionType+"NotTrue",
+				fmt.Sprintf("Condition %s is not True", conditionType))
+			return
+		}
+	}
+
+	setCondition(v, examplecomv1.ConditionReady, metav1.ConditionTrue, "AllComponentsReady",
+		"Database, backend and frontend are ready")
+}
+
+// Returns the URL a Service can be reached at: the load balancer address when
+// one has been assigned, the in-cluster DNS name otherwise
+func serviceURL(s *corev1.Service) string {
+	port := s.Spec.Ports[0].Port
+
+	if s.Spec.Type == corev1.ServiceTypeLoadBalancer && len(s.Status.LoadBalancer.Ingress) > 0 {
+		ingress := s.Status.LoadBalancer.Ingress[0]
+		host := ingress.Hostname
+		if host == "" {
+			host = ingress.IP
+		}
+		return fmt.Sprintf("http://%s:%d", host, port)
+	}
+
+	return fmt.Sprintf("http://%s.%s.svc:%d", s.Name, s.Namespace, port)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("VisitorsApp status", func() {
+	var (
+		ctx context.Context
+		app *examplecomv1.VisitorsApp
+		r   *VisitorsAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		app = &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "status", Namespace: "default", UID: "status-uid", Generation: 2},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Backend:  examplecomv1.BackendSpec{TierSpec: examplecomv1.TierSpec{Size: 2}},
+				Frontend: examplecomv1.FrontendSpec{TierSpec: examplecomv1.TierSpec{Size: 1}},
+			},
+		}
+		r = newTestReconciler(app)
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady", Message: "MySQL accepts connections"}
+	})
+
+	condition := func(v *examplecomv1.VisitorsApp, conditionType string) metav1.Condition {
+		c := meta.FindStatusCondition(v.Status.Conditions, conditionType)
+		Expect(c).NotTo(BeNil())
+		return *c
+	}
+
+	It("reports the database that is not ready", func() {
+		r.DatabaseChecker = testDatabaseChecker{Reason: "MysqlNotFound", Message: "StatefulSet default/my-cluster-mysql is missing"}
+		v, _ := reconcileApp(ctx, r, app)
+
+		Expect(v.Status.ObservedGeneration).To(Equal(int64(2)))
+		Expect(condition(v, examplecomv1.ConditionDatabaseReady).Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition(v, examplecomv1.ConditionDatabaseReady).Reason).To(Equal("MysqlNotFound"))
+		Expect(condition(v, examplecomv1.ConditionBackendAvailable).Reason).To(Equal("DeploymentNotFound"))
+		Expect(condition(v, examplecomv1.ConditionReady).Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition(v, examplecomv1.ConditionReady).Reason).To(Equal("DatabaseReadyNotTrue"))
+		Expect(v.Status.Backend.URL).To(BeEmpty())
+	})
+
+	It("reports the replicas and the availability of the tiers", func() {
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.Status.Backend.Replicas).To(Equal(int32(2)))
+		Expect(v.Status.Backend.ReadyReplicas).To(BeZero())
+		Expect(condition(v, examplecomv1.ConditionBackendAvailable).Status).To(Equal(metav1.ConditionUnknown))
+		Expect(condition(v, examplecomv1.ConditionReady).Reason).To(Equal("BackendAvailableNotTrue"))
+
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, frontendDeploymentName(app), app.Namespace)
+		v, _ = reconcileApp(ctx, r, app)
+
+		Expect(v.Status.Backend.ReadyReplicas).To(Equal(int32(2)))
+		Expect(v.Status.Frontend.Replicas).To(Equal(int32(1)))
+		Expect(v.Status.Frontend.ReadyReplicas).To(Equal(int32(1)))
+		for _, conditionType := range []string{
+			examplecomv1.ConditionDatabaseReady,
+			examplecomv1.ConditionBackendAvailable,
+			examplecomv1.ConditionFrontendAvailable,
+			examplecomv1.ConditionReady,
+		} {
+			Expect(condition(v, conditionType).Status).To(Equal(metav1.ConditionTrue), conditionType)
+			Expect(condition(v, conditionType).ObservedGeneration).To(Equal(int64(2)), conditionType)
+		}
+		Expect(condition(v, examplecomv1.ConditionReady).Reason).To(Equal("AllComponentsReady"))
+	})
+
+	It("reports the URLs of the Services", func() {
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.Status.Backend.URL).To(Equal("http://status-backend-service.default.svc:8000"))
+		Expect(v.Status.Frontend.URL).To(Equal("http://status-frontend-service.default.svc:3000"))
+
+		s := &corev1.Service{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: frontendServiceName(app), Namespace: app.Namespace}, s)).To(Succeed())
+		s.Spec.Type = corev1.ServiceTypeLoadBalancer
+		s.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+		Expect(r.Update(ctx, s)).To(Succeed())
+		Expect(r.updateStatus(ctx, v.DeepCopy(), v)).To(Succeed())
+		Expect(v.Status.Frontend.URL).To(Equal("http://203.0.113.10:3000"))
+	})
+
+	It("writes the status only when it changed", func() {
+		r.DatabaseChecker = testDatabaseChecker{Reason: "MysqlNotReady", Message: "StatefulSet default/my-cluster-mysql has no ready replicas"}
+		waiting, _ := reconcileApp(ctx, r, app)
+		v, _ := reconcileApp(ctx, r, app)
+		Expect(v.ResourceVersion).To(Equal(waiting.ResourceVersion))
+
+		r.DatabaseChecker = testDatabaseChecker{Ready: true, Reason: "MysqlReady"}
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, backendDeploymentName(app), app.Namespace)
+		reconcileApp(ctx, r, app)
+		rollOutDeployment(ctx, r, frontendDeploymentName(app), app.Namespace)
+		ready, _ := reconcileApp(ctx, r, app)
+		Expect(ready.ResourceVersion).NotTo(Equal(waiting.ResourceVersion))
+		Expect(ready.Status.Phase).To(Equal(examplecomv1.VisitorsAppPhaseRunning))
+
+		v, _ = reconcileApp(ctx, r, app)
+		Expect(v.ResourceVersion).To(Equal(ready.ResourceVersion))
+	})
+})
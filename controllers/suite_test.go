@@ -17,19 +17,31 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
 	//+kubebuilder:scaffold:imports
 )
@@ -65,6 +77,9 @@ var _ = BeforeSuite(func() {
 	err = examplecomv1beta1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = examplecomv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	//+kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
@@ -78,3 +93,170 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// Returns a VisitorsAppReconciler running against a fake client that holds
+// objs
+func newTestReconciler(objs ...client.Object) *VisitorsAppReconciler {
+	testScheme := runtime.NewScheme()
+	Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(examplecomv1.AddToScheme(testScheme)).To(Succeed())
+
+	return &VisitorsAppReconciler{
+		Client: &applyClient{
+			Client:    fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objs...).Build(),
+			applied:   map[string]client.Object{},
+			updatedBy: map[string]string{},
+		},
+		Scheme:   testScheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// Returns the last object the reconciler applied with the given kind and name
+func lastApplied(r *VisitorsAppReconciler, kind string, name string) client.Object {
+	return r.Client.(*applyClient).applied[kind+"/"+name]
+}
+
+// The fake client does not support server-side apply. applyClient emulates it
+// by merging the applied object into the stored one, like a JSON merge patch,
+// and leaves the stored object untouched when that changes nothing. Fields
+// left out of an apply are kept rather than removed.
+type applyClient struct {
+	client.Client
+
+	// Last object applied, by kind and name
+	applied map[string]client.Object
+	// Field manager of the last update, by kind and name
+	updatedBy map[string]string
+}
+
+// Returns the field manager the reconciler last updated the object with the
+// given kind and name with
+func lastUpdatedBy(r *VisitorsAppReconciler, kind string, name string) string {
+	return r.Client.(*applyClient).updatedBy[kind+"/"+name]
+}
+
+func (c *applyClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	options := &client.UpdateOptions{}
+	options.ApplyOptions(opts)
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+	c.updatedBy[gvk.Kind+"/"+obj.GetName()] = options.FieldManager
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	c.applied[obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.GetName()] = obj.DeepCopyObject().(client.Object)
+
+	current := obj.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if apierrors.IsNotFound(err) {
+		obj.SetResourceVersion("")
+		obj.SetGeneration(1)
+		return c.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	stored, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return err
+	}
+	storedSpec := runtime.DeepCopyJSONValue(stored["spec"])
+	applied, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	merged := current.DeepCopyObject().(client.Object)
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(mergeFields(stored, applied), merged)
+	if err != nil {
+		return err
+	}
+	merged.GetObjectKind().SetGroupVersionKind(current.GetObjectKind().GroupVersionKind())
+
+	if !equality.Semantic.DeepEqual(merged, current) {
+		// Like the API server, count the changes to the spec in the
+		// generation, so that the status of a Deployment tells whether it
+		// observed them
+		if mergedFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(merged); err == nil &&
+			!equality.Semantic.DeepEqual(mergedFields["spec"], storedSpec) {
+			merged.SetGeneration(current.GetGeneration() + 1)
+		}
+		merged.SetResourceVersion(current.GetResourceVersion())
+		if err := c.Client.Update(ctx, merged); err != nil {
+			return err
+		}
+	}
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(merged)
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(data, obj)
+}
+
+// Merges the fields of applied into stored, replacing lists as a whole
+func mergeFields(stored map[string]interface{}, applied map[string]interface{}) map[string]interface{} {
+	for key, value := range applied {
+		if value == nil {
+			continue
+		}
+		storedFields, storedIsMap := stored[key].(map[string]interface{})
+		appliedFields, appliedIsMap := value.(map[string]interface{})
+		if storedIsMap && appliedIsMap {
+			stored[key] = mergeFields(storedFields, appliedFields)
+		} else {
+			stored[key] = value
+		}
+	}
+	return stored
+}
+
+// Reconciles the VisitorsApp and returns it as stored afterwards
+func reconcileApp(ctx context.Context, r *VisitorsAppReconciler, app *examplecomv1.VisitorsApp) (*examplecomv1.VisitorsApp, ctrl.Result) {
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+	Expect(err).NotTo(HaveOccurred())
+
+	v := &examplecomv1.VisitorsApp{}
+	Expect(r.Get(ctx, client.ObjectKeyFromObject(app), v)).To(Succeed())
+	return v, result
+}
+
+// Reports every pod of the Deployment as updated and available, as the
+// Deployment controller would once it rolled out
+func rollOutDeployment(ctx context.Context, r *VisitorsAppReconciler, name string, namespace string) *appsv1.Deployment {
+	dep := &appsv1.Deployment{}
+	Expect(r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, dep)).To(Succeed())
+
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	dep.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: dep.Generation,
+		Replicas:           replicas,
+		UpdatedReplicas:    replicas,
+		ReadyReplicas:      replicas,
+		AvailableReplicas:  replicas,
+		Conditions: []appsv1.DeploymentCondition{{
+			Type:    appsv1.DeploymentAvailable,
+			Status:  corev1.ConditionTrue,
+			Reason:  "MinimumReplicasAvailable",
+			Message: "Deployment has minimum availability.",
+		}},
+	}
+	Expect(r.Status().Update(ctx, dep)).To(Succeed())
+	return dep
+}
+
+// Reports the same DatabaseStatus for every VisitorsApp
+type testDatabaseChecker DatabaseStatus
+
+func (c testDatabaseChecker) CheckDatabase(context.Context, *examplecomv1.VisitorsApp) DatabaseStatus {
+	return DatabaseStatus(c)
+}
@@ -22,23 +22,45 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
-	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 	//api "github.com/presslabs/mysql-operator/pkg/apis/mysql/v1alpha1"
 )
 
 // VisitorsAppReconciler reconciles a VisitorsApp object
 type VisitorsAppReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// DatabaseChecker decides whether MySQL is ready, it defaults to a
+	// StatefulSetChecker
+	DatabaseChecker DatabaseChecker
+	// Clock tells how long MySQL has been down, the time scheduled backups
+	// are due at and how long rollout steps were held, it defaults to the
+	// real clock
+	Clock clock.PassiveClock
+}
+
+func (r *VisitorsAppReconciler) clock() clock.PassiveClock {
+	if r.Clock != nil {
+		return r.Clock
+	}
+	return clock.RealClock{}
 }
 
 //+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsapps,verbs=get;list;watch;create;update;patch;delete
@@ -46,9 +68,15 @@ type VisitorsAppReconciler struct {
 //+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsapps/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=mysql.presslabs.org,resources=mysqlclusters,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -58,13 +86,13 @@ type VisitorsAppReconciler struct {
 // the user.
 //
 // For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.9.2/pkg/reconcile
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.2/pkg/reconcile
 func (r *VisitorsAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrllog.FromContext(ctx)
 	log.Info("Reconciling VisitorsApp")
 
 	// Fetch the VisitorsApp instance
-	v := &examplecomv1beta1.VisitorsApp{}
+	v := &examplecomv1.VisitorsApp{}
 	err := r.Get(ctx, req.NamespacedName, v)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -72,6 +100,7 @@ func (r *VisitorsAppReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			log.Info("VisitorsApp resource not found. Ignoring since object must be deleted")
+			deleteMetrics(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -79,83 +108,217 @@ func (r *VisitorsAppReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
-	var result *ctrl.Result
+	if !v.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, v)
+	}
+
+	err = r.ensureFinalizer(ctx, v)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Status is computed from the owned objects and written once, whatever
+	// step the reconcile stopped at
+	original := v.DeepCopy()
+	result, err := r.reconcile(ctx, req, v)
+	if err == nil {
+		// Scheduled backups wait for the database on their own, so they are
+		// handled whatever step the reconcile stopped at
+		var next time.Duration
+		next, err = r.ensureScheduledBackups(ctx, v)
+		if next > 0 && (result.RequeueAfter == 0 || next < result.RequeueAfter) {
+			result.RequeueAfter = next
+		}
+	}
+	if err != nil {
+		r.recordReconcileFailed(v, err)
+	}
+
+	statusErr := r.updateStatus(ctx, original, v)
+	if statusErr != nil {
+		log.Error(statusErr, "Failed to update VisitorsApp status")
+		if err == nil {
+			// Requeue the request if the status could not be updated
+			return ctrl.Result{}, statusErr
+		}
+	}
+
+	return result, err
+}
 
-	// == MySQL ==========
+// Brings the database, backend and frontend in line with the spec, in that order
+func (r *VisitorsAppReconciler) reconcile(ctx context.Context, req ctrl.Request, v *examplecomv1.VisitorsApp) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
 
-	mysqlRunning := r.isMysqlUp(ctx, v)
+	for _, phase := range []struct {
+		name   string
+		ensure func(context.Context, ctrl.Request, *examplecomv1.VisitorsApp) (*ctrl.Result, error)
+	}{
+		{phaseDatabase, r.reconcileDatabase},
+		{phaseBackend, r.reconcileBackend},
+		{phaseFrontend, r.reconcileFrontend},
+	} {
+		result, err := phase.ensure(ctx, req, v)
+		observeReconcilePhase(v, phase.name, result, err)
+		if result != nil {
+			return *result, err
+		}
+	}
 
-	if !mysqlRunning {
-		// If MySQL isn't running yet, requeue the reconcile
-		// to run again after a delay
-		delay := time.Second * time.Duration(5)
+	// == Finish ==========
+	// Everything went fine, don't requeue
+	log.Info("Everything went fine, don't requeue.")
+	return ctrl.Result{}, nil
+}
 
-		log.Info(fmt.Sprintf("MySQL isn't running, waiting for %s", delay))
-		return ctrl.Result{RequeueAfter: delay}, nil
+// == MySQL ==========
+func (r *VisitorsAppReconciler) reconcileDatabase(ctx context.Context, req ctrl.Request, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	if v.Spec.Database.Managed {
+		result, err := r.ensureMysqlCluster(ctx, v)
+		if result != nil {
+			return result, err
+		}
+	}
+
+	database := r.databaseChecker().CheckDatabase(ctx, v)
+	setDatabaseCondition(v, database)
+
+	if !database.Ready {
+		// The StatefulSet is watched, so the reconcile runs again as soon as
+		// MySQL comes up. Requeuing is only a fallback, backing off the longer
+		// MySQL has been down.
+		delay := r.databaseBackoff(v)
+
+		log.Info(fmt.Sprintf("MySQL isn't running, waiting for %s", delay), "Reason", database.Reason, "Message", database.Message)
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonWaitingForDatabase,
+			"MySQL isn't running, waiting for %s", delay)
+		return &ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	if v.Spec.Database.Schema != nil {
+		result, err := r.ensureSchema(ctx, v)
+		if result != nil {
+			return result, err
+		}
+	} else {
+		clearSchemaStatus(v)
 	}
 
 	log.Info("Database setup completed.")
+	return nil, nil
+}
+
+// == Visitors Backend  ==========
+func (r *VisitorsAppReconciler) reconcileBackend(ctx context.Context, req ctrl.Request, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
 
-	// == Visitors Backend  ==========
-	result, err = r.ensureDeployment(ctx, req, v, r.backendDeployment(v))
+	dep, err := r.backendDeploymentWithCredentials(ctx, v)
+	if err != nil {
+		log.Error(err, "Failed to read the database credentials")
+		return &ctrl.Result{}, err
+	}
+
+	dep, wait, err := r.ensureBackendRollout(ctx, req, v, dep)
+	if err != nil {
+		return wait, err
+	}
+
+	result, err := r.ensureService(ctx, req, v, r.backendService(v))
 	if result != nil {
-		return *result, err
+		return result, err
 	}
 
-	result, err = r.ensureService(ctx, req, v, r.backendService(v))
+	result, err = r.ensureHorizontalPodAutoscaler(ctx, v, "backend",
+		backendHorizontalPodAutoscalerName(v), backendDeploymentName(v), v.Spec.Backend.AutoScaling.HorizontalPodAutoscaler)
 	if result != nil {
-		return *result, err
+		return result, err
 	}
 
-	err = r.updateBackendStatus(ctx, v)
-	if err != nil {
-		// Requeue the request if the status could not be updated
-		return ctrl.Result{}, err
+	// The pods of a rollout go away once it completed or was aborted
+	for _, strategy := range []examplecomv1.RolloutStrategyType{examplecomv1.RolloutStrategyCanary, examplecomv1.RolloutStrategyBlueGreen} {
+		if rolloutInProgress(v) && v.Status.BackendRollout.Strategy == strategy {
+			continue
+		}
+		result, err = r.ensureDeleted(ctx, v, newDeployment(backendCandidateDeploymentName(v, strategy), v.Namespace))
+		if result != nil {
+			return result, err
+		}
 	}
 
-	result, err = r.handleBackendChanges(ctx, v)
-	if result != nil {
-		return *result, err
+	if wait != nil {
+		var phase examplecomv1.RolloutPhase
+		if v.Status.BackendRollout != nil {
+			phase = v.Status.BackendRollout.Phase
+		}
+		log.Info("Waiting for the backend rollout before updating the frontend", "Phase", phase)
+		return wait, nil
+	}
+
+	// The frontend is neither created nor updated until the backend it calls
+	// runs the current spec. The Deployment is owned, so its rollout
+	// progress triggers the reconcile again.
+	if !deploymentRolledOut(dep) {
+		log.Info("Waiting for the backend rollout before updating the frontend", "Deployment.Name", dep.Name)
+		r.Recorder.Eventf(v, corev1.EventTypeNormal, eventReasonWaitingForBackend,
+			"Waiting for the rollout of %s before updating the frontend", describe("Deployment", dep))
+		return &ctrl.Result{}, nil
 	}
 
 	log.Info("Backend setup completed.")
+	return nil, nil
+}
+
+// == Visitors Frontend ==========
+func (r *VisitorsAppReconciler) reconcileFrontend(ctx context.Context, req ctrl.Request, v *examplecomv1.VisitorsApp) (*ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
 
-	// == Visitors Frontend ==========
-	result, err = r.ensureDeployment(ctx, req, v, r.frontendDeployment(v))
+	result, err := r.ensureTierDeployment(ctx, req, v, "frontend", r.frontendDeployment(v))
 	if result != nil {
-		return *result, err
+		return result, err
 	}
 
 	result, err = r.ensureService(ctx, req, v, r.frontendService(v))
 	if result != nil {
-		return *result, err
-	}
-
-	err = r.updateFrontendStatus(ctx, v)
-	if err != nil {
-		// Requeue the request
-		return ctrl.Result{}, err
+		return result, err
 	}
 
-	result, err = r.handleFrontendChanges(ctx, v)
+	result, err = r.ensureHorizontalPodAutoscaler(ctx, v, "frontend",
+		frontendHorizontalPodAutoscalerName(v), frontendDeploymentName(v), v.Spec.Frontend.AutoScaling.HorizontalPodAutoscaler)
 	if result != nil {
-		return *result, err
+		return result, err
 	}
 
 	log.Info("Frontend setup completed.")
 
-	// == Finish ==========
-	// Everything went fine, don't requeue
-	log.Info("Everything went fine, don't requeue.")
-	return ctrl.Result{}, nil
+	// == Ingress ==========
+	return r.ensureIngress(ctx, v)
 }
 
 // SetupWithManager sets up the controller with the Manager.
+// The MysqlCluster is only watched when the presslabs CRD is installed, so the
+// operator keeps working against hand-made clusters without it.
 func (r *VisitorsAppReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&examplecomv1beta1.VisitorsApp{}).
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&examplecomv1.VisitorsApp{}).
 		Owns(&appsv1.Deployment{}).
-		Owns(&appsv1.StatefulSet{}).
 		Owns(&corev1.Service{}).
-		Complete(r)
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}}, r.mysqlStatefulSetHandler()).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, r.mysqlSecretHandler()).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, r.migrationsHandler()).
+		Watches(&source.Kind{Type: &examplecomv1.VisitorsAppBackup{}}, r.scheduledBackupHandler()).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, r.tierPodHandler())
+
+	_, err := mgr.GetRESTMapper().RESTMapping(mysqlClusterGVK.GroupKind(), mysqlClusterGVK.Version)
+	if err == nil {
+		builder = builder.Watches(&source.Kind{Type: newMysqlCluster()}, r.mysqlClusterHandler())
+	} else if !meta.IsNoMatchError(err) {
+		return err
+	}
+
+	return builder.Complete(r)
 }
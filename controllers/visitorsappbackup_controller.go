@@ -0,0 +1,270 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+)
+
+// VisitorsAppBackupReconciler reconciles a VisitorsAppBackup object
+type VisitorsAppBackupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsappbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsappbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsappbackups/finalizers,verbs=update
+
+// Reconcile runs the Job dumping the database of the VisitorsApp once its
+// database is ready, and records the spec of the VisitorsApp with it. With the
+// Delete deletion policy, the dump is removed before the backup goes away.
+func (r *VisitorsAppBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	b := &examplecomv1.VisitorsAppBackup{}
+	err := r.Get(ctx, req.NamespacedName, b)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get VisitorsAppBackup")
+		return ctrl.Result{}, err
+	}
+
+	if !b.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, b)
+	}
+
+	if b.Spec.DeletionPolicy == examplecomv1.BackupDeletionPolicyDelete && !controllerutil.ContainsFinalizer(b, visitorsAppFinalizer) {
+		controllerutil.AddFinalizer(b, visitorsAppFinalizer)
+		err = r.Update(ctx, b)
+		if err != nil {
+			log.Error(err, "Failed to add the finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if b.Status.Phase == examplecomv1.BackupPhaseCompleted || b.Status.Phase == examplecomv1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	original := b.DeepCopy()
+	err = r.reconcile(ctx, b)
+
+	if !equality.Semantic.DeepEqual(original.Status, b.Status) {
+		statusErr := r.Status().Patch(ctx, b, client.MergeFrom(original))
+		if statusErr != nil {
+			log.Error(statusErr, "Failed to update VisitorsAppBackup status")
+			if err == nil {
+				err = statusErr
+			}
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *VisitorsAppBackupReconciler) reconcile(ctx context.Context, b *examplecomv1.VisitorsAppBackup) error {
+	log := ctrllog.FromContext(ctx)
+
+	err := validateBackupTarget(b.Spec.Target)
+	if err != nil {
+		r.setPhase(b, examplecomv1.BackupPhaseFailed, err.Error())
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: backupJobName(b), Namespace: b.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Job")
+		return err
+	}
+
+	exists := err == nil
+
+	if !exists || b.Status.StartTime == nil {
+		v := &examplecomv1.VisitorsApp{}
+		err = r.Get(ctx, types.NamespacedName{Name: b.Spec.VisitorsAppName, Namespace: b.Namespace}, v)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get VisitorsApp")
+			return err
+		}
+
+		if !exists {
+			if errors.IsNotFound(err) {
+				r.setPhase(b, examplecomv1.BackupPhasePending, fmt.Sprintf("VisitorsApp %s not found", b.Spec.VisitorsAppName))
+				return nil
+			}
+			if !meta.IsStatusConditionTrue(v.Status.Conditions, examplecomv1.ConditionDatabaseReady) {
+				r.setPhase(b, examplecomv1.BackupPhasePending, fmt.Sprintf("Waiting for the database of VisitorsApp %s", v.Name))
+				return nil
+			}
+			var hasRootPassword bool
+			hasRootPassword, err = hasMysqlRootPassword(ctx, r, v)
+			if err != nil {
+				log.Error(err, "Failed to read the database credentials")
+				return err
+			}
+			if !hasRootPassword {
+				message := fmt.Sprintf("Secret %s has no key %s", mysqlAuthName(v), mysqlRootPasswordKey(v))
+				r.Recorder.Event(b, corev1.EventTypeWarning, eventReasonFailed, message)
+				r.setPhase(b, examplecomv1.BackupPhaseFailed, message)
+				return nil
+			}
+
+			job = backupJob(b, v, backupJobName(b), b.Namespace, b.Spec.Image, false)
+			controllerutil.SetControllerReference(b, job, r.Scheme)
+			log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			err = r.Create(ctx, job)
+			if err != nil {
+				log.Error(err, "Failed to create new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+				return err
+			}
+			r.Recorder.Event(b, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("Job", job))
+		}
+
+		// Also done for a Job that exists already, in case the status
+		// update following its creation was lost
+		now := metav1.Now()
+		b.Status.StartTime = &now
+		b.Status.Location = backupLocation(b)
+		if err == nil {
+			b.Status.MysqlVersion = mysqlVersion(v)
+			b.Status.VisitorsAppSpec = v.Spec.DeepCopy()
+		}
+	}
+
+	finished, err := jobFinished(job)
+	switch {
+	case !finished:
+		r.setPhase(b, examplecomv1.BackupPhaseRunning, fmt.Sprintf("Dumping the database of VisitorsApp %s to %s",
+			b.Spec.VisitorsAppName, b.Status.Location))
+	case err != nil:
+		r.Recorder.Event(b, corev1.EventTypeWarning, eventReasonFailed, err.Error())
+		r.setPhase(b, examplecomv1.BackupPhaseFailed, err.Error())
+	default:
+		r.Recorder.Event(b, corev1.EventTypeNormal, eventReasonCompleted, "Backed up to "+b.Status.Location)
+		r.setPhase(b, examplecomv1.BackupPhaseCompleted, "Backed up to "+b.Status.Location)
+	}
+	return nil
+}
+
+// Runs the Job removing the dump of a completed backup that is being deleted,
+// then removes the finalizer once it finished. A failed Job is reported but
+// does not block the deletion.
+func (r *VisitorsAppBackupReconciler) finalize(ctx context.Context, b *examplecomv1.VisitorsAppBackup) error {
+	log := ctrllog.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(b, visitorsAppFinalizer) {
+		return nil
+	}
+
+	if b.Status.Phase == examplecomv1.BackupPhaseCompleted {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: removeDumpJobName(b), Namespace: b.Namespace}, job)
+		if errors.IsNotFound(err) {
+			job = removeDumpJob(b)
+			controllerutil.SetControllerReference(b, job, r.Scheme)
+			log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			err = r.Create(ctx, job)
+			if err != nil {
+				log.Error(err, "Failed to create new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			}
+			return err
+		}
+		if err != nil {
+			log.Error(err, "Failed to get Job")
+			return err
+		}
+
+		finished, err := jobFinished(job)
+		switch {
+		case !finished:
+			return nil
+		case err != nil:
+			log.Error(err, "Failed to remove the dump, it is kept", "Location", b.Status.Location)
+			r.Recorder.Eventf(b, corev1.EventTypeWarning, eventReasonDumpRemoveFailed, "Failed to remove %s, it is kept: %s",
+				b.Status.Location, err)
+		default:
+			r.Recorder.Event(b, corev1.EventTypeNormal, eventReasonDumpRemoved, "Removed "+b.Status.Location)
+		}
+	}
+
+	log.Info("Removing the finalizer")
+	controllerutil.RemoveFinalizer(b, visitorsAppFinalizer)
+	err := r.Update(ctx, b)
+	if err != nil {
+		log.Error(err, "Failed to remove the finalizer")
+	}
+	return err
+}
+
+func (r *VisitorsAppBackupReconciler) setPhase(b *examplecomv1.VisitorsAppBackup, phase examplecomv1.BackupPhase, message string) {
+	b.Status.Phase = phase
+	b.Status.Message = message
+	if phase == examplecomv1.BackupPhaseCompleted || phase == examplecomv1.BackupPhaseFailed {
+		now := metav1.Now()
+		b.Status.CompletionTime = &now
+	}
+}
+
+// Maps a VisitorsApp to the backups of it that did not start yet
+func (r *VisitorsAppBackupReconciler) backupsOf(obj client.Object) []reconcile.Request {
+	backups := &examplecomv1.VisitorsAppBackupList{}
+	err := r.List(context.Background(), backups, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, b := range backups.Items {
+		if b.Spec.VisitorsAppName == obj.GetName() && b.Status.StartTime == nil {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&b)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VisitorsAppBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&examplecomv1.VisitorsAppBackup{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &examplecomv1.VisitorsApp{}}, handler.EnqueueRequestsFromMapFunc(r.backupsOf)).
+		Complete(r)
+}
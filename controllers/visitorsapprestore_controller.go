@@ -0,0 +1,262 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+)
+
+// VisitorsAppRestoreReconciler reconciles a VisitorsAppRestore object
+type VisitorsAppRestoreReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsapprestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsapprestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=example.com.my.domain,resources=visitorsapprestores/finalizers,verbs=update
+
+// Reconcile recreates the VisitorsApp of a completed backup if it is missing,
+// then runs the Job loading the dump once its database is ready.
+func (r *VisitorsAppRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+
+	restore := &examplecomv1.VisitorsAppRestore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get VisitorsAppRestore")
+		return ctrl.Result{}, err
+	}
+
+	if restore.Status.Phase == examplecomv1.BackupPhaseCompleted || restore.Status.Phase == examplecomv1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	original := restore.DeepCopy()
+	err = r.reconcile(ctx, restore)
+
+	if !equality.Semantic.DeepEqual(original.Status, restore.Status) {
+		statusErr := r.Status().Patch(ctx, restore, client.MergeFrom(original))
+		if statusErr != nil {
+			log.Error(statusErr, "Failed to update VisitorsAppRestore status")
+			if err == nil {
+				err = statusErr
+			}
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *VisitorsAppRestoreReconciler) reconcile(ctx context.Context, restore *examplecomv1.VisitorsAppRestore) error {
+	log := ctrllog.FromContext(ctx)
+
+	b := &examplecomv1.VisitorsAppBackup{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.BackupName, Namespace: restore.Namespace}, b)
+	if errors.IsNotFound(err) {
+		r.setPhase(restore, examplecomv1.BackupPhasePending, fmt.Sprintf("VisitorsAppBackup %s not found", restore.Spec.BackupName))
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to get VisitorsAppBackup")
+		return err
+	}
+
+	switch b.Status.Phase {
+	case examplecomv1.BackupPhaseCompleted:
+	case examplecomv1.BackupPhaseFailed:
+		r.setPhase(restore, examplecomv1.BackupPhaseFailed, fmt.Sprintf("VisitorsAppBackup %s failed", b.Name))
+		return nil
+	default:
+		r.setPhase(restore, examplecomv1.BackupPhasePending, fmt.Sprintf("Waiting for VisitorsAppBackup %s to complete", b.Name))
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: restoreJobName(restore), Namespace: restore.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get Job")
+		return err
+	}
+
+	if errors.IsNotFound(err) {
+		v, err := r.ensureVisitorsApp(ctx, restore, b)
+		if err != nil || v == nil {
+			return err
+		}
+
+		if !meta.IsStatusConditionTrue(v.Status.Conditions, examplecomv1.ConditionDatabaseReady) {
+			r.setPhase(restore, examplecomv1.BackupPhasePending, fmt.Sprintf("Waiting for the database of VisitorsApp %s", v.Name))
+			return nil
+		}
+
+		job = backupJob(b, v, restoreJobName(restore), restore.Namespace, restore.Spec.Image, true)
+		controllerutil.SetControllerReference(restore, job, r.Scheme)
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.Create(ctx, job)
+		if err != nil {
+			log.Error(err, "Failed to create new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return err
+		}
+		r.Recorder.Event(restore, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("Job", job))
+	}
+
+	if restore.Status.StartTime == nil {
+		now := metav1.Now()
+		restore.Status.StartTime = &now
+	}
+
+	finished, err := jobFinished(job)
+	switch {
+	case !finished:
+		r.setPhase(restore, examplecomv1.BackupPhaseRunning, fmt.Sprintf("Loading %s into VisitorsApp %s",
+			b.Status.Location, restoreTarget(restore, b)))
+	case err != nil:
+		r.Recorder.Event(restore, corev1.EventTypeWarning, eventReasonFailed, err.Error())
+		r.setPhase(restore, examplecomv1.BackupPhaseFailed, err.Error())
+	default:
+		message := fmt.Sprintf("Restored %s into VisitorsApp %s", b.Status.Location, restoreTarget(restore, b))
+		r.Recorder.Event(restore, corev1.EventTypeNormal, eventReasonCompleted, message)
+		r.setPhase(restore, examplecomv1.BackupPhaseCompleted, message)
+	}
+	return nil
+}
+
+// Returns the name of the VisitorsApp a restore loads the backup into
+func restoreTarget(restore *examplecomv1.VisitorsAppRestore, b *examplecomv1.VisitorsAppBackup) string {
+	if restore.Spec.VisitorsAppName != "" {
+		return restore.Spec.VisitorsAppName
+	}
+	return b.Spec.VisitorsAppName
+}
+
+// Returns the VisitorsApp to restore into, creating it from the spec recorded
+// by the backup when it does not exist. It returns nil while the VisitorsApp
+// cannot be used yet, with the reason in the phase of the restore.
+func (r *VisitorsAppRestoreReconciler) ensureVisitorsApp(ctx context.Context, restore *examplecomv1.VisitorsAppRestore, b *examplecomv1.VisitorsAppBackup) (*examplecomv1.VisitorsApp, error) {
+	log := ctrllog.FromContext(ctx)
+	name := restoreTarget(restore, b)
+
+	v := &examplecomv1.VisitorsApp{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: restore.Namespace}, v)
+	if err == nil {
+		if !v.DeletionTimestamp.IsZero() {
+			r.setPhase(restore, examplecomv1.BackupPhasePending, fmt.Sprintf("Waiting for VisitorsApp %s to be deleted", name))
+			return nil, nil
+		}
+		return v, nil
+	}
+	if !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get VisitorsApp")
+		return nil, err
+	}
+
+	if b.Status.VisitorsAppSpec == nil {
+		r.setPhase(restore, examplecomv1.BackupPhaseFailed,
+			fmt.Sprintf("VisitorsApp %s not found and VisitorsAppBackup %s recorded no spec to recreate it", name, b.Name))
+		return nil, nil
+	}
+
+	v = &examplecomv1.VisitorsApp{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: restore.Namespace,
+		},
+		Spec: *b.Status.VisitorsAppSpec.DeepCopy(),
+	}
+	log.Info("Recreating the VisitorsApp", "VisitorsApp.Namespace", v.Namespace, "VisitorsApp.Name", v.Name)
+	err = r.Create(ctx, v)
+	if err != nil {
+		log.Error(err, "Failed to create VisitorsApp", "VisitorsApp.Namespace", v.Namespace, "VisitorsApp.Name", v.Name)
+		return nil, err
+	}
+	r.Recorder.Event(restore, corev1.EventTypeNormal, eventReasonCreated, "Created "+describe("VisitorsApp", v))
+
+	r.setPhase(restore, examplecomv1.BackupPhasePending, fmt.Sprintf("Waiting for the database of VisitorsApp %s", name))
+	return nil, nil
+}
+
+func (r *VisitorsAppRestoreReconciler) setPhase(restore *examplecomv1.VisitorsAppRestore, phase examplecomv1.BackupPhase, message string) {
+	restore.Status.Phase = phase
+	restore.Status.Message = message
+	if phase == examplecomv1.BackupPhaseCompleted || phase == examplecomv1.BackupPhaseFailed {
+		now := metav1.Now()
+		restore.Status.CompletionTime = &now
+	}
+}
+
+// Maps a backup, or a VisitorsApp, to the restores waiting for it
+func (r *VisitorsAppRestoreReconciler) restoresWaitingFor(obj client.Object) []reconcile.Request {
+	ctx := context.Background()
+	restores := &examplecomv1.VisitorsAppRestoreList{}
+	err := r.List(ctx, restores, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		return nil
+	}
+
+	_, isBackup := obj.(*examplecomv1.VisitorsAppBackup)
+	var requests []reconcile.Request
+	for _, restore := range restores.Items {
+		if restore.Status.Phase != "" && restore.Status.Phase != examplecomv1.BackupPhasePending {
+			continue
+		}
+
+		waiting := restore.Spec.BackupName == obj.GetName()
+		if !isBackup {
+			b := &examplecomv1.VisitorsAppBackup{}
+			err = r.Get(ctx, types.NamespacedName{Name: restore.Spec.BackupName, Namespace: restore.Namespace}, b)
+			waiting = err == nil && restoreTarget(&restore, b) == obj.GetName()
+		}
+		if waiting {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&restore)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VisitorsAppRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&examplecomv1.VisitorsAppRestore{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &examplecomv1.VisitorsAppBackup{}}, handler.EnqueueRequestsFromMapFunc(r.restoresWaitingFor)).
+		Watches(&source.Kind{Type: &examplecomv1.VisitorsApp{}}, handler.EnqueueRequestsFromMapFunc(r.restoresWaitingFor)).
+		Complete(r)
+}
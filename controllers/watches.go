@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// The MySQL StatefulSet, the MysqlCluster, the credentials Secret and the
+// migrations ConfigMap are not necessarily owned by the VisitorsApp using
+// them, an unmanaged cluster can even live in another namespace. Their events
+// are mapped back to every VisitorsApp referencing them instead.
+
+func (r *VisitorsAppReconciler) mysqlStatefulSetHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return r.requestsReferencing(obj, "", func(v *examplecomv1.VisitorsApp) types.NamespacedName {
+			return types.NamespacedName{Name: mysqlStatefulSetName(v), Namespace: mysqlNamespace(v)}
+		})
+	})
+}
+
+func (r *VisitorsAppReconciler) mysqlClusterHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return r.requestsReferencing(obj, "", func(v *examplecomv1.VisitorsApp) types.NamespacedName {
+			return types.NamespacedName{Name: mysqlClusterName(v), Namespace: mysqlNamespace(v)}
+		})
+	})
+}
+
+// The backend pods read the credentials from a Secret of their own namespace
+func (r *VisitorsAppReconciler) mysqlSecretHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return r.requestsReferencing(obj, obj.GetNamespace(), func(v *examplecomv1.VisitorsApp) types.NamespacedName {
+			return types.NamespacedName{Name: mysqlAuthName(v), Namespace: v.Namespace}
+		})
+	})
+}
+
+func (r *VisitorsAppReconciler) migrationsHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return r.requestsReferencing(obj, obj.GetNamespace(), func(v *examplecomv1.VisitorsApp) types.NamespacedName {
+			if v.Spec.Database.Schema == nil || v.Spec.Database.Schema.MigrationsConfigMap == "" {
+				return types.NamespacedName{}
+			}
+			return types.NamespacedName{Name: v.Spec.Database.Schema.MigrationsConfigMap, Namespace: v.Namespace}
+		})
+	})
+}
+
+// Scheduled backups are not owned by their VisitorsApp so that they outlive
+// it, yet it prunes them as they finish
+func (r *VisitorsAppReconciler) scheduledBackupHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if obj.GetLabels()["tier"] != scheduledBackupTier {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{
+			Name:      obj.GetLabels()["visitorssite_cr"],
+			Namespace: obj.GetNamespace(),
+		}}}
+	})
+}
+
+// Pods are owned by the ReplicaSets of the Deployments, the VisitorsApp
+// watches them to roll back a release as soon as its pods crash loop
+func (r *VisitorsAppReconciler) tierPodHandler() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if obj.GetLabels()["app"] != "visitors" || obj.GetLabels()["visitorssite_cr"] == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{
+			Name:      obj.GetLabels()["visitorssite_cr"],
+			Namespace: obj.GetNamespace(),
+		}}}
+	})
+}
+
+// Returns a request for each VisitorsApp of the namespace, or of all of them
+// when it is empty, whose reference points at obj
+func (r *VisitorsAppReconciler) requestsReferencing(obj client.Object, namespace string, reference func(*examplecomv1.VisitorsApp) types.NamespacedName) []reconcile.Request {
+	ctx := context.Background()
+	apps := &examplecomv1.VisitorsAppList{}
+	err := r.List(ctx, apps, client.InNamespace(namespace))
+	if err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Failed to list the VisitorsApps referencing "+obj.GetName())
+		return nil
+	}
+
+	key := client.ObjectKeyFromObject(obj)
+	var requests []reconcile.Request
+	for i := range apps.Items {
+		if reference(&apps.Items[i]) == key {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&apps.Items[i]),
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("VisitorsApp watches", func() {
+	var r *VisitorsAppReconciler
+
+	BeforeEach(func() {
+		managed := &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "managed", Namespace: "default"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{Managed: true},
+			},
+		}
+		shared := &examplecomv1.VisitorsApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "apps"},
+			Spec: examplecomv1.VisitorsAppSpec{
+				Database: examplecomv1.DatabaseSpec{ClusterName: "shared", Namespace: "databases"},
+			},
+		}
+
+		r = newTestReconciler(managed, shared)
+	})
+
+	request := func(name string, namespace string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	}
+
+	// Returns the requests the handler enqueues for the creation of obj
+	enqueued := func(h handler.EventHandler, obj client.Object) []reconcile.Request {
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+
+		h.Create(event.CreateEvent{Object: obj}, queue)
+
+		var requests []reconcile.Request
+		for queue.Len() > 0 {
+			item, _ := queue.Get()
+			requests = append(requests, item.(reconcile.Request))
+			queue.Done(item)
+		}
+		return requests
+	}
+
+	It("maps a MySQL StatefulSet to the VisitorsApps using it, across namespaces", func() {
+		statefulset := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "shared-mysql", Namespace: "databases"}}
+		Expect(enqueued(r.mysqlStatefulSetHandler(), statefulset)).To(ConsistOf(request("shared", "apps")))
+
+		statefulset = &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "managed-db-mysql", Namespace: "default"}}
+		Expect(enqueued(r.mysqlStatefulSetHandler(), statefulset)).To(ConsistOf(request("managed", "default")))
+
+		statefulset = &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "other-mysql", Namespace: "databases"}}
+		Expect(enqueued(r.mysqlStatefulSetHandler(), statefulset)).To(BeEmpty())
+	})
+
+	It("maps a MysqlCluster to the VisitorsApps using it, across namespaces", func() {
+		cluster := newMysqlCluster()
+		cluster.SetName("shared")
+		cluster.SetNamespace("databases")
+		Expect(enqueued(r.mysqlClusterHandler(), cluster)).To(ConsistOf(request("shared", "apps")))
+
+		cluster = newMysqlCluster()
+		cluster.SetName("managed-db")
+		cluster.SetNamespace("default")
+		Expect(enqueued(r.mysqlClusterHandler(), cluster)).To(ConsistOf(request("managed", "default")))
+	})
+
+	It("maps a credentials Secret to the VisitorsApps of its namespace only", func() {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "apps"}}
+		Expect(enqueued(r.mysqlSecretHandler(), secret)).To(ConsistOf(request("shared", "apps")))
+
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "databases"}}
+		Expect(enqueued(r.mysqlSecretHandler(), secret)).To(BeEmpty())
+	})
+
+	It("backs off the longer MySQL is down", func() {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		r.Clock = clocktesting.NewFakePassiveClock(now)
+
+		v := &examplecomv1.VisitorsApp{}
+		Expect(r.databaseBackoff(v)).To(Equal(databaseBackoffMin))
+
+		meta.SetStatusCondition(&v.Status.Conditions, metav1.Condition{
+			Type:               examplecomv1.ConditionDatabaseReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "MysqlNotReady",
+			LastTransitionTime: metav1.NewTime(now.Add(-40 * time.Second)),
+		})
+		Expect(r.databaseBackoff(v)).To(Equal(40 * time.Second))
+
+		v.Status.Conditions[0].LastTransitionTime = metav1.NewTime(now.Add(-time.Hour))
+		Expect(r.databaseBackoff(v)).To(Equal(databaseBackoffMax))
+	})
+})
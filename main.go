@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -31,6 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	examplecomv1 "github.com/ringdrx/visitors-operator/api/v1"
 	examplecomv1beta1 "github.com/ringdrx/visitors-operator/api/v1beta1"
 	"github.com/ringdrx/visitors-operator/controllers"
 	//+kubebuilder:scaffold:imports
@@ -45,6 +47,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(examplecomv1beta1.AddToScheme(scheme))
+	utilruntime.Must(examplecomv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,11 +55,15 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var databaseConnectivityCheck bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&databaseConnectivityCheck, "database-connectivity-check", false,
+		"Only consider MySQL ready once its read-write service accepts connections. "+
+			"The operator has to run in the cluster for the service names to resolve.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,13 +85,45 @@ func main() {
 		os.Exit(1)
 	}
 
+	var databaseChecker controllers.DatabaseChecker = &controllers.StatefulSetChecker{Reader: mgr.GetClient()}
+	if databaseConnectivityCheck {
+		databaseChecker = controllers.DatabaseCheckers{
+			databaseChecker,
+			&controllers.TCPChecker{Timeout: 5 * time.Second},
+		}
+	}
+
 	if err = (&controllers.VisitorsAppReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Recorder:        mgr.GetEventRecorderFor("visitorsapp-controller"),
+		DatabaseChecker: databaseChecker,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VisitorsApp")
 		os.Exit(1)
 	}
+	if err = (&controllers.VisitorsAppBackupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("visitorsappbackup-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VisitorsAppBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.VisitorsAppRestoreReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("visitorsapprestore-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VisitorsAppRestore")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&examplecomv1.VisitorsApp{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "VisitorsApp")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {